@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	aiutil "github.com/ztkent/ai-util"
+	"github.com/ztkent/moki/internal/config"
+	"github.com/ztkent/moki/internal/prompts"
+	"github.com/ztkent/moki/internal/resources"
+	"github.com/ztkent/moki/internal/tokenizer"
+	"github.com/ztkent/moki/internal/tools"
+)
+
+const commitUsage = `Usage:
+	moki commit           Draft a commit message for the staged changes, then accept, edit or cancel it
+	moki commit -print    Only print the drafted message`
+
+// RunCommitCommand drafts a conventional commit message from the staged changes.
+// The message can be accepted, edited before committing, or cancelled.
+func RunCommitCommand(client aiutil.Client, settings config.Settings, args []string) error {
+	flags := flag.NewFlagSet("commit", flag.ContinueOnError)
+	printFlag := flags.Bool("print", false, "Only print the drafted message")
+	flags.Usage = func() { fmt.Fprintln(os.Stderr, commitUsage) }
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	label, staged, err := resources.Git(context.Background(), "staged")
+	if err != nil {
+		return fmt.Errorf("Failed to read the staged changes, stage them with git add first: %w", err)
+	}
+	conv := aiutil.NewConversation(prompts.CommitPrompt, *settings.MaxTokens, true)
+	// Large changes keep the start and end of the diff, instead of failing
+	fit := &resources.Fit{Strategy: tokenizer.HeadTail}
+	if _, err := fit.Attach(conv, label, staged); err != nil {
+		return err
+	}
+	// A new repository has no log to follow
+	if label, log, err := resources.Git(context.Background(), "log:10"); err == nil {
+		if _, err := fit.Attach(conv, label, log); err != nil {
+			return err
+		}
+	}
+	for _, warning := range fit.Warnings {
+		fmt.Fprintln(os.Stderr, "Warning: "+warning)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*60)
+	defer cancel()
+	response, err := client.SendCompletionRequest(ctx, conv, "Write a commit message for the staged changes.")
+	if err != nil {
+		return err
+	}
+	message := tools.StripCodeFences(response)
+	if message == "" {
+		return fmt.Errorf("The commit message was empty")
+	}
+
+	fmt.Println(message)
+	if *printFlag || !tools.IsTerminal(os.Stdin) {
+		return nil
+	}
+
+	fmt.Print("\n[A]ccept, [e]dit, [c]ancel: ")
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("Failed to read the answer: %w", err)
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "", "a", "accept":
+		return gitCommit(message, false)
+	case "e", "edit":
+		return gitCommit(message, true)
+	default:
+		fmt.Println("Cancelled.")
+		return nil
+	}
+}
+
+// gitCommit commits the staged changes with the message.
+// If edit is set, git opens the message in the user's editor first.
+func gitCommit(message string, edit bool) error {
+	f, err := os.CreateTemp("", "moki-commit-*.txt")
+	if err != nil {
+		return fmt.Errorf("Failed to write the commit message: %w", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(message + "\n"); err != nil {
+		f.Close()
+		return fmt.Errorf("Failed to write the commit message: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Failed to write the commit message: %w", err)
+	}
+
+	args := []string{"commit", "-F", f.Name()}
+	if edit {
+		args = append(args, "--edit")
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Failed to commit: %w", err)
+	}
+	return nil
+}
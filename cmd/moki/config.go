@@ -0,0 +1,211 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	aiutil "github.com/ztkent/ai-util"
+	"github.com/ztkent/moki/internal/config"
+	"github.com/ztkent/moki/internal/environment"
+	"github.com/ztkent/moki/internal/providers"
+	"github.com/ztkent/moki/internal/resources"
+	"github.com/ztkent/moki/internal/session"
+	"github.com/ztkent/moki/internal/tokenizer"
+	"github.com/ztkent/moki/internal/tools"
+	"github.com/ztkent/moki/internal/usage"
+)
+
+// resolveSettings loads the config files, and layers the settings.
+// Only flags that were explicitly set override the other layers.
+func resolveSettings(profile string, flagValues config.Settings) (config.Settings, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		cwd = "."
+	}
+	cfg, err := config.Load(cwd)
+	if err != nil {
+		return config.Settings{}, err
+	}
+
+	flags := config.Settings{}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "llm":
+			flags.Provider = flagValues.Provider
+		case "m":
+			flags.Model = flagValues.Model
+		case "base-url":
+			flags.BaseURL = flagValues.BaseURL
+		case "t":
+			flags.Temperature = flagValues.Temperature
+		case "max-tokens":
+			flags.MaxTokens = flagValues.MaxTokens
+		case "r":
+			flags.Resources = flagValues.Resources
+		case "x":
+			flags.Exec = flagValues.Exec
+		case "o":
+			flags.Output = flagValues.Output
+		case "no-color":
+			flags.NoColor = flagValues.NoColor
+		case "env":
+			flags.EnvContext = flagValues.EnvContext
+		case "env-fields":
+			flags.EnvFields = flagValues.EnvFields
+		case "truncate":
+			flags.Truncate = flagValues.Truncate
+		case "resource-tokens":
+			flags.ResourceTokens = flagValues.ResourceTokens
+		case "compact-at":
+			flags.CompactAt = flagValues.CompactAt
+		case "retries":
+			flags.Retries = flagValues.Retries
+		case "fallback":
+			flags.Fallbacks = slices.DeleteFunc(flagValues.Fallbacks, func(f string) bool { return strings.TrimSpace(f) == "" })
+		}
+	})
+
+	settings, err := config.Resolve(cfg, profile, flags)
+	if err != nil {
+		return settings, err
+	}
+	if !slices.Contains(outputFormats, settings.Output) {
+		return settings, fmt.Errorf("Unsupported output format: %s (supported: %s)", settings.Output, strings.Join(outputFormats, ", "))
+	}
+	if _, err := tokenizer.ParseStrategy(settings.Truncate); err != nil {
+		return settings, err
+	}
+	if *settings.ResourceTokens < 0 {
+		return settings, fmt.Errorf("Invalid resource tokens: %d", *settings.ResourceTokens)
+	}
+	if *settings.CompactAt < 0 || *settings.CompactAt > 1 {
+		return settings, fmt.Errorf("Invalid compact at: %v, use a fraction of the token budget between 0 and 1", *settings.CompactAt)
+	}
+	if *settings.Retries < 0 {
+		return settings, fmt.Errorf("Invalid retries: %d", *settings.Retries)
+	}
+	for _, fallback := range settings.Fallbacks {
+		if _, _, err := providers.ParseFallback(fallback); err != nil {
+			return settings, err
+		}
+	}
+	logger.WithFields(logrus.Fields{
+		"Provider":    settings.Provider,
+		"Model":       settings.Model,
+		"BaseURL":     settings.BaseURL,
+		"Temperature": *settings.Temperature,
+		"MaxTokens":   *settings.MaxTokens,
+		"Resources":   *settings.Resources,
+		"Exec":        *settings.Exec,
+		"Output":      settings.Output,
+		"EnvContext":  *settings.EnvContext,
+		"EnvFields":   settings.EnvFields,
+		"Truncate":    settings.Truncate,
+		"Retries":     *settings.Retries,
+		"Fallbacks":   settings.Fallbacks,
+		"Profile":     profile,
+	}).Debugln("Resolved settings")
+	return settings, nil
+}
+
+// resumeSettings switches to the provider and model of the session being resumed, unless -llm or -m was set.
+// The base URL is only kept for the same provider, unless -base-url was set.
+func resumeSettings(settings config.Settings, resumeID string) (config.Settings, error) {
+	if flagSet("llm", "m") {
+		return settings, nil
+	}
+	store, err := session.NewStore("")
+	if err != nil {
+		return settings, err
+	}
+	sess, err := store.Load(resumeID)
+	if err != nil {
+		return settings, err
+	}
+	if sess.Provider == "" {
+		return settings, nil
+	}
+	if sess.Provider != settings.Provider && !flagSet("base-url") {
+		settings.BaseURL = ""
+	}
+	settings.Provider, settings.Model = sess.Provider, sess.Model
+	return settings, nil
+}
+
+// flagSet reports whether any of the flags were set on the command line.
+func flagSet(names ...string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		set = set || slices.Contains(names, f.Name)
+	})
+	return set
+}
+
+// addEnvironment adds the user's environment to the conversation as a reference.
+func addEnvironment(conv *aiutil.Conversation, settings config.Settings) error {
+	if !*settings.EnvContext {
+		return nil
+	}
+	fields, err := environment.ParseFields(strings.Join(settings.EnvFields, ","))
+	if err != nil {
+		return err
+	}
+	envContext := environment.Collect(fields).String()
+	if envContext == "" {
+		return nil
+	}
+	return conv.AddReference(environment.ReferenceID, envContext)
+}
+
+// systemPrompt returns the configured prompt, or the default prompt for the mode.
+func systemPrompt(settings config.Settings, defaultPrompt string) string {
+	if settings.Prompt != "" {
+		return settings.Prompt
+	}
+	return defaultPrompt
+}
+
+// resourceOptions returns how resources are attached, from the settings.
+func resourceOptions(settings config.Settings, confirm resources.ConfirmFunc) tools.ResourceOptions {
+	// The strategy was checked when the settings were resolved
+	strategy, _ := tokenizer.ParseStrategy(settings.Truncate)
+	return tools.ResourceOptions{
+		Confirm:   confirm,
+		Truncate:  strategy,
+		MaxTokens: *settings.ResourceTokens,
+	}
+}
+
+// connector creates clients that record their usage, retry failed requests, and fall back to the configured models.
+// The options of a fallback are the same as the client's, with its own provider and model.
+// Fallbacks connect the first time they are needed, so one that is unavailable is skipped, rather than stopping moki.
+func connector(settings config.Settings, tracker *usage.Tracker, policy providers.RetryPolicy) func(opts ...aiutil.Option) (aiutil.Client, error) {
+	return func(opts ...aiutil.Option) (aiutil.Client, error) {
+		client, err := providers.NewAIClient(opts...)
+		if err != nil {
+			return nil, err
+		}
+		fallbacks := make([]providers.Fallback, 0, len(settings.Fallbacks))
+		for _, fallback := range settings.Fallbacks {
+			// The fallbacks were checked when the settings were resolved
+			provider, model, _ := providers.ParseFallback(fallback)
+			fallbackOpts := append(slices.Clone(opts), aiutil.WithProvider(provider), aiutil.WithModel(model), aiutil.WithBaseURL(""))
+			fallbacks = append(fallbacks, providers.Fallback{
+				Provider: provider,
+				Model:    model,
+				Connect: func() (aiutil.Client, error) {
+					fallbackClient, err := providers.NewAIClient(fallbackOpts...)
+					if err != nil {
+						return nil, err
+					}
+					return tracker.Wrap(fallbackClient), nil
+				},
+			})
+		}
+		return providers.WithRetries(tracker.Wrap(client), policy, fallbacks...), nil
+	}
+}
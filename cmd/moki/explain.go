@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	aiutil "github.com/ztkent/ai-util"
+	"github.com/ztkent/moki/internal/config"
+	"github.com/ztkent/moki/internal/explain"
+	"github.com/ztkent/moki/internal/prompts"
+	"github.com/ztkent/moki/internal/tools"
+)
+
+const explainUsage = `Usage:
+	moki explain [-o text|json] -- <command>
+	echo '<command>' | moki explain [-o text|json]`
+
+// RunExplainCommand breaks a command down into its parts.
+// The command is read from the arguments, or from stdin if there are none.
+func RunExplainCommand(client aiutil.Client, settings config.Settings, args []string) error {
+	flags := flag.NewFlagSet("explain", flag.ContinueOnError)
+	defaultOutput := OutputText
+	if settings.Output == OutputJSON {
+		defaultOutput = OutputJSON
+	}
+	outputFlag := flags.String("o", defaultOutput, "Output format: text or json")
+	flags.Usage = func() { fmt.Fprintln(os.Stderr, explainUsage) }
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *outputFlag != OutputText && *outputFlag != OutputJSON {
+		return fmt.Errorf("Unsupported output format: %s", *outputFlag)
+	}
+
+	command := strings.TrimSpace(strings.Join(flags.Args(), " "))
+	if command == "" {
+		command = strings.TrimSpace(tools.ReadFromStdinPipe())
+	}
+	if command == "" {
+		fmt.Println(explainUsage)
+		return fmt.Errorf("Please provide a command to explain")
+	}
+
+	conv := aiutil.NewConversation(prompts.ExplainPrompt, *settings.MaxTokens, false)
+	if err := addEnvironment(conv, settings); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*60)
+	defer cancel()
+	response, err := client.SendCompletionRequest(ctx, conv, command)
+	if err != nil {
+		return err
+	}
+	explanation, err := explain.Parse(command, response)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case *outputFlag == OutputJSON:
+		return explain.WriteJSON(os.Stdout, explanation)
+	case !tools.IsTerminal(os.Stdout):
+		return explain.WritePlain(os.Stdout, explanation)
+	}
+	return explain.WriteTable(os.Stdout, explanation)
+}
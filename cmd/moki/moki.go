@@ -5,14 +5,24 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	aiutil "github.com/ztkent/ai-util"
+	"github.com/ztkent/moki/internal/analyzer"
+	"github.com/ztkent/moki/internal/config"
 	"github.com/ztkent/moki/internal/conversation"
+	"github.com/ztkent/moki/internal/execute"
 	"github.com/ztkent/moki/internal/prompts"
+	"github.com/ztkent/moki/internal/providers"
+	"github.com/ztkent/moki/internal/render"
+	"github.com/ztkent/moki/internal/resources"
+	"github.com/ztkent/moki/internal/session"
+	"github.com/ztkent/moki/internal/shell"
 	"github.com/ztkent/moki/internal/tools"
+	"github.com/ztkent/moki/internal/usage"
 )
 
 /*
@@ -24,7 +34,8 @@ var logger = logrus.New()
 func init() {
 	// Setup the logger, so it can be parsed by datadog
 	logger.Formatter = &logrus.JSONFormatter{}
-	logger.SetOutput(os.Stdout)
+	// Logs go to stderr, so they never mix with the response
+	logger.SetOutput(os.Stderr)
 	// Set the log level
 	logLevel := strings.ToLower(os.Getenv("LOG_LEVEL"))
 	switch logLevel {
@@ -42,12 +53,26 @@ func init() {
 func main() {
 	// Define the flags
 	helpFlag := flag.Bool("h", false, "Show this message")
+	outputFlag := flag.String("o", OutputText, "Output format for one-shot requests: text, json or raw")
 	convFlag := flag.Bool("c", false, "Start a conversation with Moki")
-	aiFlag := flag.String("llm", string(aiutil.OpenAI), "Select the LLM provider, either OpenAI or Replicate")
+	aiFlag := flag.String("llm", string(aiutil.OpenAI), "Select the LLM provider: openai, replicate, anthropic, gemini, openai-compatible or mock")
 	modelFlag := flag.String("m", "", "Set the model to use for the LLM response (uses provider default if empty)")
+	baseURLFlag := flag.String("base-url", "", "Set the base URL of the LLM API, e.g. http://localhost:11434/v1")
 	temperatureFlag := flag.Float64("t", aiutil.DefaultTemp, "Set the temperature for the LLM response")
 	maxTokensFlag := flag.Int("max-tokens", aiutil.DefaultMaxTokens, "Set the maximum number of tokens to generate per response")
 	resourcesFlag := flag.Bool("r", true, "Enable resources functionality")
+	execFlag := flag.Bool("x", false, "Confirm, edit and run the suggested command")
+	noColorFlag := flag.Bool("no-color", false, "Print raw markdown, instead of rendering it")
+	envFlag := flag.Bool("env", true, "Send the OS, shell, package managers, cwd and git state as context")
+	envFieldsFlag := flag.String("env-fields", "", "Comma separated environment fields to send (default all)")
+	truncateFlag := flag.String("truncate", "head+tail", "What to keep of a resource that doesn't fit: head, tail, head+tail or relevant")
+	compactAtFlag := flag.Float64("compact-at", 0.8, "Summarize older messages when a conversation fills this fraction of its tokens, 0 disables it")
+	resourceTokensFlag := flag.Int("resource-tokens", 0, "The most tokens a single resource can use (default the space left)")
+	retriesFlag := flag.Int("retries", providers.DefaultRetries, "Retry a request that fails with a rate limit or server error this many times")
+	fallbackFlag := flag.String("fallback", "", "Comma separated provider/model pairs to try in order when a request fails, e.g. anthropic/sonnet")
+	resumeFlag := flag.String("resume", "", "Resume a saved conversation by session id")
+	profileFlag := flag.String("profile", "", "Use a named profile from the config file")
+	ignoreLimitsFlag := flag.Bool("ignore-limits", false, "Send requests even if they go over a spending limit")
 	flagFlag := flag.Bool("flags", false, "Log the flags used for this request")
 
 	// Parse the flags
@@ -57,12 +82,26 @@ func main() {
 	if *flagFlag {
 		logger.WithFields(logrus.Fields{
 			"helpFlag":        *helpFlag,
+			"outputFlag":      *outputFlag,
 			"convFlag":        *convFlag,
 			"aiFlag":          *aiFlag,
 			"modelFlag":       *modelFlag,
+			"baseURLFlag":     *baseURLFlag,
 			"temperatureFlag": *temperatureFlag,
 			"maxTokensFlag":   *maxTokensFlag,
 			"resourcesFlag":   *resourcesFlag,
+			"resumeFlag":      *resumeFlag,
+			"execFlag":        *execFlag,
+			"profileFlag":     *profileFlag,
+			"ignoreLimits":    *ignoreLimitsFlag,
+			"envFlag":         *envFlag,
+			"noColorFlag":     *noColorFlag,
+			"envFieldsFlag":   *envFieldsFlag,
+			"truncateFlag":    *truncateFlag,
+			"resourceTokens":  *resourceTokensFlag,
+			"compactAt":       *compactAtFlag,
+			"retriesFlag":     *retriesFlag,
+			"fallbackFlag":    *fallbackFlag,
 		}).Infoln("Flags")
 	}
 
@@ -72,25 +111,136 @@ func main() {
 		return
 	}
 
-	// Build AI Client options from flags
+	// Print the shell integration snippet
+	if subcommand() == "shell-init" {
+		snippet, err := shell.Snippet(flag.Arg(1))
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"error": err,
+			}).Errorln("Failed to create shell integration")
+			os.Exit(1)
+		}
+		fmt.Print(snippet)
+		return
+	}
+
+	// Manage saved conversations
+	if subcommand() == "sessions" {
+		err := RunSessionsCommand(flag.Args()[1:])
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"error": err,
+			}).Errorln("Sessions command failed")
+		}
+		return
+	}
+
+	// Layer the flags over env vars, the selected profile and config files
+	settings, err := resolveSettings(*profileFlag, config.Settings{
+		Provider:       *aiFlag,
+		Model:          *modelFlag,
+		BaseURL:        *baseURLFlag,
+		Temperature:    temperatureFlag,
+		MaxTokens:      maxTokensFlag,
+		Resources:      resourcesFlag,
+		Exec:           execFlag,
+		Output:         *outputFlag,
+		NoColor:        noColorFlag,
+		EnvContext:     envFlag,
+		EnvFields:      strings.Split(*envFieldsFlag, ","),
+		Truncate:       *truncateFlag,
+		ResourceTokens: resourceTokensFlag,
+		CompactAt:      compactAtFlag,
+		Retries:        retriesFlag,
+		Fallbacks:      strings.Split(*fallbackFlag, ","),
+	})
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"error": err,
+		}).Errorln("Failed to load the configuration")
+		os.Exit(1)
+	}
+
+	// Summarize the recorded usage
+	if subcommand() == "usage" {
+		err := RunUsageCommand(flag.Args()[1:], settings.Limits)
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"error": err,
+			}).Errorln("Usage command failed")
+		}
+		return
+	}
+
+	// A resumed conversation continues with the model it was saved with
+	if *resumeFlag != "" {
+		settings, err = resumeSettings(settings, *resumeFlag)
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"error": err,
+			}).Errorln("Failed to load the conversation")
+			os.Exit(1)
+		}
+	}
+
+	// Build AI Client options from the settings
 	clientOptions := []aiutil.Option{
-		aiutil.WithProvider(*aiFlag),
-		aiutil.WithTemperature(*temperatureFlag),
-		aiutil.WithMaxTokens(*maxTokensFlag),
+		aiutil.WithProvider(settings.Provider),
+		aiutil.WithTemperature(*settings.Temperature),
+		aiutil.WithMaxTokens(*settings.MaxTokens),
+	}
+
+	// Only set the model if it is explicitly configured
+	if settings.Model != "" {
+		clientOptions = append(clientOptions, aiutil.WithModel(settings.Model))
+	}
+	if settings.BaseURL != "" {
+		clientOptions = append(clientOptions, aiutil.WithBaseURL(settings.BaseURL))
 	}
 
-	// Only set the model if the flag is explicitly provided
-	if *modelFlag != "" {
-		clientOptions = append(clientOptions, aiutil.WithModel(*modelFlag))
+	// Record the tokens and cost of every request, the ledger is summarized by 'moki usage'
+	ledger, err := usage.NewLedger("")
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"error": err,
+		}).Warnln("Usage will not be recorded")
+	}
+	tracker := usage.NewTracker(ledger)
+	if !*ignoreLimitsFlag {
+		tracker.SetLimits(settings.Limits)
 	}
 
+	// Retry requests that fail with a rate limit or server error, then try the fallbacks
+	policy := providers.DefaultRetryPolicy()
+	policy.Retries = *settings.Retries
+	policy.OnRetry = func(r providers.Retry) {
+		entry := logger.WithFields(logrus.Fields{
+			"error":    r.Err,
+			"provider": r.Provider,
+			"model":    r.Model,
+			"attempt":  r.Attempt,
+			"wait":     r.Wait.String(),
+		})
+		message := "Retrying the request"
+		if r.Skipped {
+			message = "Skipping the fallback, it failed to connect"
+		}
+		// Warnings would draw over the full screen conversation
+		if *convFlag || *resumeFlag != "" {
+			entry.Debugln(message)
+		} else {
+			entry.Warnln(message)
+		}
+	}
+	connect := connector(settings, tracker, policy)
+
 	// Connect to AI Client using functional options
-	client, err := aiutil.NewAIClient(clientOptions...)
+	client, err := connect(clientOptions...)
 	if err != nil {
 		logger.WithFields(logrus.Fields{
 			"error": err,
 		}).Errorln("Failed to connect to the AI client")
-		return
+		os.Exit(1)
 	}
 
 	// Log the actual configuration being used by the client
@@ -105,16 +255,78 @@ func main() {
 		},
 	}).Debugln("Started AI Client")
 
+	// List the models available from the provider
+	if subcommand() == "models" {
+		err := ListModels(client)
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"error": err,
+			}).Errorln("Failed to list models")
+		}
+		return
+	}
+
+	// Explain an existing command
+	if subcommand() == "explain" {
+		err := RunExplainCommand(client, settings, flag.Args()[1:])
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"error": err,
+			}).Errorln("Failed to explain the command")
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Draft a commit message for the staged changes
+	if subcommand() == "commit" {
+		err := RunCommitCommand(client, settings, flag.Args()[1:])
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"error": err,
+			}).Errorln("Failed to draft the commit message")
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Determine the max tokens to use for conversations, respecting client config
 	conversationMaxTokens := aiutil.DefaultMaxTokens
 	if client.GetConfig().MaxTokens != nil {
 		conversationMaxTokens = *client.GetConfig().MaxTokens
 	}
 
-	if *convFlag {
-		// Create a new conversation with Moki
-		conv := aiutil.NewConversation(prompts.ConversationPrompt, conversationMaxTokens, *resourcesFlag)
-		err := conversation.StartConversationCLI(client, conv)
+	if *convFlag || *resumeFlag != "" {
+		// Create a new conversation with Moki, or resume a saved one
+		conv, sess, err := loadConversation(client, *resumeFlag, systemPrompt(settings, prompts.ConversationPrompt), conversationMaxTokens, *settings.Resources)
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"error": err,
+			}).Errorln("Failed to load the conversation")
+			return
+		}
+		if sess != nil {
+			tracker.SetSession(sess.ID)
+		}
+		if *resumeFlag == "" {
+			if err := addEnvironment(conv, settings); err != nil {
+				logger.WithFields(logrus.Fields{
+					"error": err,
+				}).Errorln("Failed to add the environment context")
+				return
+			}
+		}
+		err = conversation.StartConversationCLI(client, conv, conversation.Options{
+			Session:  sess,
+			Markdown: render.Enabled(*settings.NoColor),
+			NewClient: func(opts ...aiutil.Option) (aiutil.Client, error) {
+				return connect(append(slices.Clone(clientOptions), opts...)...)
+			},
+			MaxTokens: conversationMaxTokens,
+			Usage:     tracker,
+			Resources: resourceOptions(settings, nil),
+			CompactAt: *settings.CompactAt,
+		})
 		if err != nil {
 			logger.WithFields(logrus.Fields{
 				"error": err,
@@ -124,7 +336,13 @@ func main() {
 	}
 
 	// Send a request to Moki
-	conv := aiutil.NewConversation(prompts.RequestPrompt, conversationMaxTokens, *resourcesFlag)
+	conv := aiutil.NewConversation(systemPrompt(settings, prompts.RequestPrompt), conversationMaxTokens, *settings.Resources)
+	if err := addEnvironment(conv, settings); err != nil {
+		logger.WithFields(logrus.Fields{
+			"error": err,
+		}).Errorln("Failed to add the environment context")
+		os.Exit(1)
+	}
 	// Seed the conversation with some initial context to improve the AI responses
 	conv.SeedConversation(map[string]string{
 		"install Python 3.9 on Ubuntu":                         "sudo apt update && sudo apt install python3.9",
@@ -141,47 +359,178 @@ func main() {
 	}
 
 	// Respond with a single request to Moki
-	err = LogChatStream(client, conv, strings.Join(flag.Args(), " "))
+	response, err := LogChatStream(client, tracker, conv, strings.Join(flag.Args(), " "), settings.Output, resourceOptions(settings, resources.ConfirmOnTerminal))
 	if err != nil {
 		logger.WithFields(logrus.Fields{
 			"error": err,
 		}).Errorln("Failed to log new chat stream")
+		os.Exit(1)
+	}
+	if err := WriteResponse(os.Stdout, settings.Output, response); err != nil {
+		logger.WithFields(logrus.Fields{
+			"error": err,
+		}).Errorln("Failed to write the response")
+		os.Exit(1)
+	}
+	if settings.Output == OutputText && tracker.Last().Tokens() > 0 {
+		fmt.Fprintln(os.Stderr, tracker.Last())
+	}
+	if err := tracker.Err(); err != nil {
+		logger.WithFields(logrus.Fields{
+			"error": err,
+		}).Warnln("Failed to record usage")
+	}
+
+	// Warn about destructive commands, the confirm view shows them when executing
+	if !*settings.Exec && settings.Output != OutputJSON {
+		analyzer.PrintWarnings(os.Stderr, analyzer.Analyze(response.Answer))
+	}
+
+	// Optionally confirm and run the suggested command
+	if *settings.Exec && response.Answer != "" {
+		err = ExecuteResponse(response.Answer)
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"error": err,
+			}).Errorln("Failed to execute command")
+		}
 	}
 }
 
-func LogChatStream(client aiutil.Client, conv *aiutil.Conversation, userInput string) error {
+// ExecuteResponse asks the user to run, edit or cancel the suggested command.
+func ExecuteResponse(response string) error {
+	action, command, err := execute.Confirm(tools.StripCodeFences(response))
+	if err != nil {
+		return err
+	} else if action != execute.Execute {
+		fmt.Println("Cancelled.")
+		return nil
+	}
+
+	fmt.Println("$ " + command)
+	result, err := execute.Run(context.Background(), command)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Exit code: %d (%s)\n", result.ExitCode, result.Duration.Round(time.Millisecond))
+	return nil
+}
+
+// subcommand returns the first argument, if it can be a subcommand.
+// Arguments after '--' are always part of the question.
+func subcommand() string {
+	if flag.NArg() == 0 {
+		return ""
+	}
+	if i := len(os.Args) - flag.NArg() - 1; i > 0 && os.Args[i] == "--" {
+		return ""
+	}
+	return flag.Arg(0)
+}
+
+// ListModels prints the models available from the client's provider.
+func ListModels(client aiutil.Client) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+	models, err := client.ListModels(ctx)
+	if err != nil {
+		return err
+	}
+	for _, model := range models {
+		fmt.Println(model)
+	}
+	return nil
+}
+
+// loadConversation resumes the session with the given id, or starts a new one.
+// If the session store is unavailable, the conversation continues without saving.
+func loadConversation(client aiutil.Client, resumeID string, prompt string, maxTokens int, resourcesEnabled bool) (*aiutil.Conversation, *session.Session, error) {
+	store, err := session.NewStore("")
+	if err != nil {
+		if resumeID != "" {
+			return nil, nil, err
+		}
+		logger.WithFields(logrus.Fields{
+			"error": err,
+		}).Warnln("Conversation will not be saved")
+		return aiutil.NewConversation(prompt, maxTokens, resourcesEnabled), nil, nil
+	}
+
+	if resumeID != "" {
+		sess, err := store.Load(resumeID)
+		if err != nil {
+			return nil, nil, err
+		}
+		return sess.Conversation(), sess, nil
+	}
+
+	conv := aiutil.NewConversation(prompt, maxTokens, resourcesEnabled)
+	sess, err := store.New(client, conv)
+	if err != nil {
+		return nil, nil, err
+	}
+	return conv, sess, nil
+}
+
+// LogChatStream sends a single request, and returns the complete response.
+// In text mode the response is printed as it is streamed, other modes print nothing.
+// The usage of the request is taken from the tracker that wraps the client.
+func LogChatStream(client aiutil.Client, tracker *usage.Tracker, conv *aiutil.Conversation, userInput string, output string, opts tools.ResourceOptions) (Response, error) {
 	oneMin, cancel := context.WithTimeout(context.Background(), time.Second*60)
 	defer cancel()
 
+	// Only text mode writes progress to stdout
+	status := os.Stdout
+	if output != OutputText {
+		status = os.Stderr
+	}
+
 	// Start the chat with a fresh conversation, and the users prompt
 	responseChan, errChan := make(chan string), make(chan error)
 
 	// Check if the user's input contains a resource command
-	modifiedInput, resourcesAdded, err := tools.ManageResources(conv, userInput)
+	modifiedInput, resourcesAdded, warnings, err := tools.ManageResources(context.Background(), conv, userInput, opts)
+	for _, warning := range warnings {
+		fmt.Fprintln(os.Stderr, "Warning: "+warning)
+	}
 	if err != nil {
-		return err
+		return Response{}, err
 	}
 	if len(modifiedInput) == 0 {
-		fmt.Println("Please provide a message to continue the conversation.")
-		return nil
-	} else if len(resourcesAdded) > 0 {
-		fmt.Println("Resources added to conversation: ", strings.Join(resourcesAdded, ","))
+		fmt.Fprintln(status, "Please provide a message to continue the conversation.")
+		return Response{}, nil
+	} else if len(resourcesAdded) > 0 && output != OutputJSON {
+		fmt.Fprintln(status, "Resources added to conversation: ", strings.Join(resourcesAdded, ","))
 	}
 
+	start := time.Now()
 	go client.SendStreamRequest(oneMin, conv, modifiedInput, responseChan, errChan)
 	// Read the response from the channel as it is streamed
+	var fullResponse strings.Builder
 	for {
 		select {
 		case response, ok := <-responseChan:
 			if !ok {
 				// Request channel closed
+				if output == OutputText {
+					fmt.Println()
+				}
+				return newResponse(client, fullResponse.String(), resourcesAdded, time.Since(start), tracker.Last()), nil
+			}
+			fullResponse.WriteString(response)
+			if output == OutputText {
+				fmt.Print(response)
+			}
+		case err, ok := <-errChan:
+			// The error channel is closed before the response channel, so wait for the response to finish
+			if !ok {
+				errChan = nil
+				continue
+			}
+			if output == OutputText {
 				fmt.Println()
-				return nil
 			}
-			fmt.Print(response)
-		case err := <-errChan:
-			fmt.Println()
-			return err
+			return newResponse(client, fullResponse.String(), resourcesAdded, time.Since(start), usage.Usage{}), err
 		}
 	}
 }
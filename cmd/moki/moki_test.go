@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	aiutil "github.com/ztkent/ai-util"
+	"github.com/ztkent/moki/internal/providers"
+	"github.com/ztkent/moki/internal/tools"
+	"github.com/ztkent/moki/internal/usage"
+)
+
+// noStdin replaces stdin with an empty file, so nothing is read from a pipe the tests were started with.
+func noStdin(t *testing.T) {
+	t.Helper()
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin := os.Stdin
+	os.Stdin = devNull
+	t.Cleanup(func() {
+		os.Stdin = stdin
+		devNull.Close()
+	})
+}
+
+// captureStdout returns what fn writes to stdout.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	done := make(chan string)
+	go func() {
+		out, _ := io.ReadAll(r)
+		done <- string(out)
+	}()
+	fn()
+	os.Stdout = stdout
+	w.Close()
+	return <-done
+}
+
+func TestLogChatStream(t *testing.T) {
+	noStdin(t)
+	tracker := usage.NewTracker(nil)
+	client := tracker.Wrap(providers.MustMockClient("mock-1", providers.MockResponse{Chunks: []string{"ls", " -la"}}))
+	conv := aiutil.NewConversation("system", 10000, true)
+
+	var response Response
+	var err error
+	out := captureStdout(t, func() {
+		response, err = LogChatStream(client, tracker, conv, "list all files", OutputText, tools.ResourceOptions{})
+	})
+	if err != nil {
+		t.Fatalf("LogChatStream: %v", err)
+	}
+	if out != "ls -la\n" {
+		t.Errorf("printed %q, want the streamed response", out)
+	}
+	if response.Answer != "ls -la" || response.Provider != string(providers.Mock) || response.Model != "mock-1" {
+		t.Errorf("response = %+v, want the answer from mock/mock-1", response)
+	}
+	if response.Usage.PromptTokens == 0 || response.Usage.CompletionTokens == 0 {
+		t.Errorf("usage = %+v, want the tracked tokens", response.Usage)
+	}
+	if len(conv.Messages) != 3 || conv.Messages[2].Content != "ls -la" {
+		t.Errorf("messages = %v, want the prompt and answer added", conv.Messages)
+	}
+}
+
+func TestLogChatStreamQuiet(t *testing.T) {
+	noStdin(t)
+	for _, output := range []string{OutputJSON, OutputRaw} {
+		tracker := usage.NewTracker(nil)
+		client := tracker.Wrap(providers.MustMockClient("mock-1", providers.MockResponse{Text: "echo hello"}))
+		var response Response
+		var err error
+		out := captureStdout(t, func() {
+			response, err = LogChatStream(client, tracker, aiutil.NewConversation("system", 10000, true), "say hello", output, tools.ResourceOptions{})
+		})
+		if err != nil || response.Answer != "echo hello" {
+			t.Errorf("%s: response = %q, %v, want the answer", output, response.Answer, err)
+		}
+		if out != "" {
+			t.Errorf("%s: printed %q, want nothing until the response is written", output, out)
+		}
+	}
+}
+
+func TestLogChatStreamError(t *testing.T) {
+	noStdin(t)
+	tracker := usage.NewTracker(nil)
+	client := tracker.Wrap(providers.MustMockClient("mock-1", providers.MockResponse{Chunks: []string{"partial", " answer"}, Status: 429, ErrorAfter: 1}))
+	conv := aiutil.NewConversation("system", 10000, true)
+	response, err := LogChatStream(client, tracker, conv, "rate limit", OutputJSON, tools.ResourceOptions{})
+	if providers.StatusCode(err) != 429 {
+		t.Fatalf("error = %v, want the 429 from the provider", err)
+	}
+	if response.Answer != "partial" {
+		t.Errorf("answer = %q, want the part that was streamed", response.Answer)
+	}
+	if len(conv.Messages) != 1 {
+		t.Errorf("messages = %v, want the prompt removed after the error", conv.Messages)
+	}
+}
+
+func TestLogChatStreamFallback(t *testing.T) {
+	noStdin(t)
+	tracker := usage.NewTracker(nil)
+	policy := providers.DefaultRetryPolicy()
+	policy.Sleep = func(ctx context.Context, d time.Duration) error { return nil }
+	primary := tracker.Wrap(providers.MustMockClient("mock-1", providers.MockResponse{Status: 503}))
+	fallback := providers.Fallback{Provider: string(providers.Mock), Model: "mock-2", Connect: func() (aiutil.Client, error) {
+		return tracker.Wrap(providers.MustMockClient("mock-2", providers.MockResponse{Text: "from the fallback"})), nil
+	}}
+	client := providers.WithRetries(primary, policy, fallback)
+
+	response, err := LogChatStream(client, tracker, aiutil.NewConversation("system", 10000, true), "hello", OutputJSON, tools.ResourceOptions{})
+	if err != nil {
+		t.Fatalf("LogChatStream: %v", err)
+	}
+	if response.Answer != "from the fallback" || response.Model != "mock-2" {
+		t.Errorf("response = %+v, want the answer and model of the fallback", response)
+	}
+	if response.Usage.CompletionTokens == 0 {
+		t.Errorf("usage = %+v, want the fallback's usage", response.Usage)
+	}
+}
+
+func TestLogChatStreamResources(t *testing.T) {
+	noStdin(t)
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(path, []byte("remember the milk"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tracker := usage.NewTracker(nil)
+	client := tracker.Wrap(providers.MustMockClient("mock-1", providers.MockResponse{Match: "^summarize$", Text: "milk"}))
+	conv := aiutil.NewConversation("system", 10000, true)
+
+	response, err := LogChatStream(client, tracker, conv, "summarize -file:"+path, OutputJSON, tools.ResourceOptions{})
+	if err != nil {
+		t.Fatalf("LogChatStream: %v", err)
+	}
+	if len(response.Resources) != 1 || !strings.HasPrefix(response.Resources[0], "file:"+path) {
+		t.Errorf("resources = %v, want the file", response.Resources)
+	}
+	found := false
+	for _, msg := range conv.Messages {
+		found = found || strings.Contains(msg.Content, "remember the milk")
+	}
+	if !found {
+		t.Errorf("messages = %v, want the file attached", conv.Messages)
+	}
+
+	// A message with only resources isn't sent
+	out := captureStdout(t, func() {
+		response, err = LogChatStream(client, tracker, conv, "-file:"+path, OutputText, tools.ResourceOptions{})
+	})
+	if err != nil || response.Answer != "" || !strings.Contains(out, "Please provide a message") {
+		t.Errorf("response = %+v, %v, printed %q, want a request for a message", response, err, out)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	aiutil "github.com/ztkent/ai-util"
+	"github.com/ztkent/moki/internal/analyzer"
+	"github.com/ztkent/moki/internal/tools"
+	"github.com/ztkent/moki/internal/usage"
+)
+
+// Output formats for one-shot requests.
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+	OutputRaw  = "raw"
+)
+
+var outputFormats = []string{OutputText, OutputJSON, OutputRaw}
+
+// Response is the result of a one-shot request, as written in json mode.
+type Response struct {
+	Answer    string   `json:"answer"`
+	Provider  string   `json:"provider"`
+	Model     string   `json:"model"`
+	Usage     Usage    `json:"usage"`
+	LatencyMS int64    `json:"latency_ms"`
+	Resources []string `json:"resources"`
+	Warnings  []string `json:"warnings"`
+}
+
+// Usage is the estimated number of tokens used by a request, and its cost if the model's price is known.
+type Usage struct {
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	CostUSD          float64 `json:"cost_usd,omitempty"`
+}
+
+// newResponse collects the details of a completed request.
+// Token usage is estimated by the tracker, since streamed responses don't report it.
+func newResponse(client aiutil.Client, answer string, resources []string, latency time.Duration, used usage.Usage) Response {
+	warnings := []string{}
+	for _, warning := range analyzer.Analyze(answer) {
+		warnings = append(warnings, warning.String())
+	}
+	return Response{
+		Answer:   answer,
+		Provider: client.GetConfig().Provider,
+		Model:    client.GetConfig().Model,
+		Usage: Usage{
+			PromptTokens:     used.PromptTokens,
+			CompletionTokens: used.CompletionTokens,
+			TotalTokens:      used.Tokens(),
+			CostUSD:          used.Cost,
+		},
+		LatencyMS: latency.Milliseconds(),
+		Resources: resources,
+		Warnings:  warnings,
+	}
+}
+
+// WriteResponse writes a completed response in the json or raw format.
+// Text responses are printed while they stream, so there is nothing left to write.
+func WriteResponse(w io.Writer, output string, response Response) error {
+	switch output {
+	case OutputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(response)
+	case OutputRaw:
+		_, err := fmt.Fprintln(w, tools.StripCodeFences(response.Answer))
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/ztkent/moki/internal/conversation"
+	"github.com/ztkent/moki/internal/render"
+	"github.com/ztkent/moki/internal/session"
+)
+
+const sessionsUsage = `Usage:
+	moki sessions list           List saved conversations
+	moki sessions show <id>      Print a saved conversation
+	moki sessions delete <id>    Delete a saved conversation
+	moki -c -resume <id>         Resume a saved conversation`
+
+// RunSessionsCommand handles the 'moki sessions' subcommands.
+func RunSessionsCommand(args []string) error {
+	if len(args) == 0 {
+		fmt.Println(sessionsUsage)
+		return nil
+	}
+
+	store, err := session.NewStore("")
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list", "ls":
+		sessions, skipped, err := store.List()
+		if err != nil {
+			return err
+		}
+		for _, err := range skipped {
+			fmt.Fprintln(os.Stderr, "Warning: skipping a session that can't be read: "+err.Error())
+		}
+		if len(sessions) == 0 {
+			fmt.Println("No saved sessions.")
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tUPDATED\tMODEL\tMESSAGES\tTITLE")
+		for _, sess := range sessions {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", sess.ID, sess.UpdatedAt.Format("2006-01-02 15:04"), sess.Model, sess.MessageCount(), sess.Title())
+		}
+		return w.Flush()
+	case "show":
+		if len(args) < 2 {
+			return fmt.Errorf("Please provide a session id: moki sessions show <id>")
+		}
+		sess, err := store.Load(args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Session: %s\nModel: %s (%s)\nUpdated: %s\n\n", sess.ID, sess.Model, sess.Provider, sess.UpdatedAt.Format("2006-01-02 15:04"))
+		conversation.PrintHistory(sess.Conversation(), render.Enabled(false))
+		return nil
+	case "delete", "rm":
+		if len(args) < 2 {
+			return fmt.Errorf("Please provide a session id: moki sessions delete <id>")
+		}
+		for _, id := range args[1:] {
+			if err := store.Delete(id); err != nil {
+				return err
+			}
+			fmt.Println("Deleted session " + id)
+		}
+		return nil
+	default:
+		fmt.Println(sessionsUsage)
+		return fmt.Errorf("Unknown sessions command: %s", args[0])
+	}
+}
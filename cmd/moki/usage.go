@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ztkent/moki/internal/usage"
+)
+
+const usageUsage = `Usage:
+	moki usage              Summarize the tokens and cost per day and model, for the last 30 days
+	moki usage -days 7      Summarize the last 7 days`
+
+// RunUsageCommand summarizes the recorded usage per day and model, and how much of each limit is spent.
+func RunUsageCommand(args []string, limits usage.Limits) error {
+	flags := flag.NewFlagSet("usage", flag.ContinueOnError)
+	daysFlag := flags.Int("days", 30, "Number of days to summarize, including today")
+	flags.Usage = func() { fmt.Fprintln(os.Stderr, usageUsage) }
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *daysFlag < 1 {
+		return fmt.Errorf("Invalid number of days: %d", *daysFlag)
+	}
+
+	ledger, err := usage.NewLedger("")
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	since := time.Date(now.Year(), now.Month(), now.Day()-(*daysFlag-1), 0, 0, 0, 0, time.Local)
+	entries, err := ledger.Entries(since)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Printf("No usage recorded in the last %d days.\n", *daysFlag)
+		return nil
+	}
+
+	total := usage.Usage{}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DAY\tPROVIDER\tMODEL\tREQUESTS\tTOKENS\tCOST")
+	for _, s := range usage.Summarize(entries) {
+		cost := "-"
+		if s.Priced {
+			cost = usage.FormatCost(s.Cost)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", s.Day, s.Provider, s.Model, s.Requests, usage.FormatTokens(s.Tokens()), cost)
+		total.Add(s.Usage)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("\nTotal: %d requests, %s\n", len(entries), total)
+	return printLimits(ledger, limits)
+}
+
+// printLimits shows how much of the daily and monthly limits is spent.
+// Session limits depend on the session, so they aren't shown.
+func printLimits(ledger *usage.Ledger, limits usage.Limits) error {
+	if limits.DailyUSD == nil && limits.DailyTokens == nil && limits.MonthlyUSD == nil && limits.MonthlyTokens == nil {
+		return nil
+	}
+	spent, err := usage.NewTracker(ledger).Spent(time.Now())
+	if err != nil {
+		return err
+	}
+	fmt.Println("\nLimits:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, period := range []struct {
+		name    string
+		spent   usage.Usage
+		dollars *float64
+		tokens  *int
+	}{
+		{"today", spent.Day, limits.DailyUSD, limits.DailyTokens},
+		{"this month", spent.Month, limits.MonthlyUSD, limits.MonthlyTokens},
+	} {
+		if period.dollars != nil {
+			fmt.Fprintf(w, "  %s\t%s of $%.2f\n", period.name, usage.FormatCost(period.spent.Cost), *period.dollars)
+		}
+		if period.tokens != nil {
+			fmt.Fprintf(w, "  %s\t%s of %s tokens\n", period.name, usage.FormatTokens(period.spent.Tokens()), usage.FormatTokens(*period.tokens))
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,210 @@
+package analyzer
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Severity describes how much damage a flagged command can do.
+type Severity int
+
+const (
+	Low Severity = iota
+	Medium
+	High
+	Critical
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Low:
+		return "LOW"
+	case Medium:
+		return "MEDIUM"
+	case High:
+		return "HIGH"
+	case Critical:
+		return "CRITICAL"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Rule flags text that matches a destructive pattern.
+type Rule struct {
+	Name        string
+	Severity    Severity
+	Description string
+	Pattern     *regexp.Regexp
+	// Also are patterns that must all match inside the text matched by Pattern, e.g. each flag of a command
+	Also []*regexp.Regexp
+}
+
+// find returns the first text the rule matches, or "" if it doesn't match.
+func (r Rule) find(text string) string {
+	if len(r.Also) == 0 {
+		return r.Pattern.FindString(text)
+	}
+	for _, match := range r.Pattern.FindAllString(text, -1) {
+		all := true
+		for _, also := range r.Also {
+			all = all && also.MatchString(match)
+		}
+		if all {
+			return match
+		}
+	}
+	return ""
+}
+
+// Warning is a rule that matched a generated command.
+type Warning struct {
+	Rule        string
+	Severity    Severity
+	Description string
+	Match       string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("[%s] %s: %s", w.Severity, w.Description, w.Match)
+}
+
+// Rules are checked in order, the first match for each rule is reported.
+var Rules = []Rule{
+	{
+		Name:        "rm-root",
+		Severity:    Critical,
+		Description: "Deletes the root filesystem or home directory",
+		Pattern:     regexp.MustCompile(`\brm\s+(?:-{1,2}[\w-]+\s+)*(?:/|/\*|~/?|~/\*|\$HOME/?|\$HOME/\*)(?:\s|$|;|&|\||` + "`" + `)`),
+	},
+	{
+		Name:        "rm-no-preserve-root",
+		Severity:    Critical,
+		Description: "Disables the rm safeguard for the root filesystem",
+		Pattern:     regexp.MustCompile(`\brm\b[^\n;&|]*--no-preserve-root`),
+	},
+	{
+		Name:        "rm-recursive-force",
+		Severity:    Medium,
+		Description: "Recursively force deletes files",
+		// The flags may be combined, separate or long, e.g. -rf, -r -f or --recursive --force
+		Pattern: regexp.MustCompile(`\brm\s[^\n;&|` + "`" + `]*`),
+		Also: []*regexp.Regexp{
+			regexp.MustCompile(`\s-(?:[a-zA-Z]*[rR][a-zA-Z]*|-recursive)(?:\s|$)`),
+			regexp.MustCompile(`\s-(?:[a-zA-Z]*f[a-zA-Z]*|-force)(?:\s|$)`),
+		},
+	},
+	{
+		Name:        "dd-device",
+		Severity:    Critical,
+		Description: "Writes directly to a block device",
+		Pattern:     regexp.MustCompile(`\bdd\b[^\n;&|]*\bof=/dev/\w+`),
+	},
+	{
+		Name:        "redirect-device",
+		Severity:    Critical,
+		Description: "Overwrites a block device",
+		Pattern:     regexp.MustCompile(`>\s*/dev/(?:sd|hd|vd|xvd|nvme|mmcblk|disk)\w*`),
+	},
+	{
+		Name:        "mkfs",
+		Severity:    Critical,
+		Description: "Formats a filesystem, erasing its contents",
+		Pattern:     regexp.MustCompile(`\bmkfs(?:\.\w+)?\b[^\n;&|]*`),
+	},
+	{
+		Name:        "chmod-root",
+		Severity:    High,
+		Description: "Recursively changes permissions from the root filesystem",
+		Pattern:     regexp.MustCompile(`\bch(?:mod|own|grp)\s+(?:[^\n;&|]*\s)?(?:-[a-zA-Z]*R[a-zA-Z]*|--recursive)\s+(?:[^\n;&|]*\s)?/(?:\*)?(?:\s|$|;|&|\||` + "`" + `)`),
+	},
+	{
+		Name:        "chmod-777",
+		Severity:    Medium,
+		Description: "Makes files writable by every user",
+		Pattern:     regexp.MustCompile(`\bchmod\s+(?:-[a-zA-Z]+\s+)*(?:0?777|a\+rwx|ugo\+rwx)\b[^\n;&|]*`),
+	},
+	{
+		Name:        "fork-bomb",
+		Severity:    Critical,
+		Description: "Fork bomb, exhausts system resources",
+		Pattern:     regexp.MustCompile(`:\s*\(\s*\)\s*\{\s*:\s*\|\s*:\s*&\s*\}\s*;\s*:`),
+	},
+	{
+		Name:        "pipe-to-shell",
+		Severity:    High,
+		Description: "Runs a remote script without reviewing it",
+		Pattern:     regexp.MustCompile(`\b(?:curl|wget)\b[^\n;&|]*\|\s*(?:sudo\s+(?:-\w+\s+)*)?(?:ba|z|da|k|fi)?sh\b`),
+	},
+	{
+		Name:        "git-force-push",
+		Severity:    Medium,
+		Description: "Force pushes, rewriting remote history",
+		Pattern:     regexp.MustCompile(`\bgit\s+push\b[^\n;&|]*\s(?:--force(?:-with-lease)?|-f)\b`),
+	},
+	{
+		Name:        "git-reset-hard",
+		Severity:    Medium,
+		Description: "Discards uncommitted changes",
+		Pattern:     regexp.MustCompile(`\bgit\s+(?:reset\s+[^\n;&|]*--hard|clean\s+(?:-\w+\s+)*-\w*f\w*)\b`),
+	},
+	{
+		Name:        "overwrite-system-file",
+		Severity:    High,
+		Description: "Overwrites or appends to a system configuration file",
+		Pattern:     regexp.MustCompile(`(?:^|[^>])>>?\s*/(?:etc|boot|usr|bin|sbin|lib)/[\w./-]+`),
+	},
+	{
+		Name:        "drop-database",
+		Severity:    High,
+		Description: "Drops a database or table",
+		Pattern:     regexp.MustCompile(`(?i)\bdrop\s+(?:database|schema|table)\b[^\n;]*`),
+	},
+}
+
+// Analyze checks text for destructive commands.
+// Warnings are returned in order of severity, most severe first.
+func Analyze(text string) []Warning {
+	warnings := []Warning{}
+	for _, rule := range Rules {
+		match := rule.find(text)
+		if match == "" {
+			continue
+		}
+		warnings = append(warnings, Warning{
+			Rule:        rule.Name,
+			Severity:    rule.Severity,
+			Description: rule.Description,
+			Match:       strings.TrimSpace(match),
+		})
+	}
+	sort.SliceStable(warnings, func(i, j int) bool {
+		return warnings[i].Severity > warnings[j].Severity
+	})
+
+	// Drop warnings already covered by a more severe match, e.g. 'rm -rf' inside 'rm -rf /'
+	deduped := []Warning{}
+	for _, warning := range warnings {
+		covered := false
+		for _, kept := range deduped {
+			if strings.Contains(kept.Match, warning.Match) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			deduped = append(deduped, warning)
+		}
+	}
+	return deduped
+}
+
+// PrintWarnings writes each warning on its own line.
+func PrintWarnings(w io.Writer, warnings []Warning) {
+	for _, warning := range warnings {
+		fmt.Fprintln(w, "Warning "+warning.String())
+	}
+}
@@ -0,0 +1,140 @@
+package analyzer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		rule  string
+		fires []string
+		quiet []string
+	}{
+		{
+			rule:  "rm-root",
+			fires: []string{"rm -rf /", "sudo rm -rf /*", "rm -rf ~", "rm -r -f ~/", "rm --recursive --force $HOME", "rm -rf / && echo done"},
+			quiet: []string{"rm -rf /tmp/build", "rm -rf ./~backup", "rm file.txt"},
+		},
+		{
+			rule:  "rm-no-preserve-root",
+			fires: []string{"sudo rm -r --no-preserve-root /mnt/data", "rm --no-preserve-root -r /mnt"},
+			quiet: []string{"echo --no-preserve-root", "rm -rf build; echo --no-preserve-root"},
+		},
+		{
+			rule:  "rm-recursive-force",
+			fires: []string{"rm -rf build", "rm -fr build", "rm -Rf build", "rm -r -f build", "rm -f -r build", "rm --recursive --force build", "rm -r --force build", "rm -v --force --recursive build", "ls; rm -rvf build"},
+			quiet: []string{"rm -r build", "rm -f file.txt", "rm --recursive build", "rm file-rf", "rm -r build; ls -f", "grep -rf patterns src"},
+		},
+		{
+			rule:  "dd-device",
+			fires: []string{"dd if=/dev/zero of=/dev/sda bs=1M", "sudo dd if=image.iso of=/dev/disk2"},
+			quiet: []string{"dd if=/dev/zero of=disk.img bs=1M count=10"},
+		},
+		{
+			rule:  "redirect-device",
+			fires: []string{"cat image > /dev/sda", "echo x >/dev/nvme0n1", "cat image >> /dev/sdb"},
+			quiet: []string{"make > /dev/null", "ls 2>/dev/null"},
+		},
+		{
+			rule:  "mkfs",
+			fires: []string{"mkfs.ext4 /dev/sdb1", "sudo mkfs -t vfat /dev/sdc"},
+			quiet: []string{"man mkfsutil-guide"},
+		},
+		{
+			rule:  "chmod-root",
+			fires: []string{"chmod -R 777 /", "chown -R user:user /", "chmod --recursive 755 /*"},
+			quiet: []string{"chmod -R 755 /var/www", "chmod 644 /etc/hosts"},
+		},
+		{
+			rule:  "chmod-777",
+			fires: []string{"chmod 777 file", "chmod -R 0777 dir", "chmod a+rwx script.sh"},
+			quiet: []string{"chmod 755 script.sh", "chmod u+x script.sh"},
+		},
+		{
+			rule:  "fork-bomb",
+			fires: []string{":(){ :|:& };:", ": ( ) { : | : & } ; :"},
+			quiet: []string{"echo :)"},
+		},
+		{
+			rule:  "pipe-to-shell",
+			fires: []string{"curl -fsSL https://example.com/install.sh | sh", "wget -qO- https://example.com | sudo bash", "curl https://example.com | zsh"},
+			quiet: []string{"curl -o install.sh https://example.com/install.sh", "curl https://example.com | jq ."},
+		},
+		{
+			rule:  "git-force-push",
+			fires: []string{"git push --force", "git push -f origin main", "git push origin main --force-with-lease"},
+			quiet: []string{"git push origin main", "git pull --force"},
+		},
+		{
+			rule:  "git-reset-hard",
+			fires: []string{"git reset --hard HEAD~1", "git clean -fd", "git clean -d -f"},
+			quiet: []string{"git reset --soft HEAD~1", "git clean -n"},
+		},
+		{
+			rule:  "overwrite-system-file",
+			fires: []string{"echo nameserver 1.1.1.1 > /etc/resolv.conf", "echo '127.0.0.1 example' >> /etc/hosts", "cat config >/usr/local/etc/app.conf"},
+			quiet: []string{"cat /etc/hosts", "echo x > ./etc/hosts", "echo x >> ~/notes.txt"},
+		},
+		{
+			rule:  "drop-database",
+			fires: []string{"DROP DATABASE production;", "psql -c 'drop table users'", "DROP SCHEMA public CASCADE"},
+			quiet: []string{"SELECT * FROM drops", "DROP INDEX idx_users"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			for _, command := range tt.fires {
+				if !fired(Analyze(command), tt.rule) {
+					t.Errorf("Analyze(%q) = %v, want %s", command, Analyze(command), tt.rule)
+				}
+			}
+			for _, command := range tt.quiet {
+				if fired(Analyze(command), tt.rule) {
+					t.Errorf("Analyze(%q) = %v, want no %s", command, Analyze(command), tt.rule)
+				}
+			}
+		})
+	}
+}
+
+func TestAnalyzeSafeCommands(t *testing.T) {
+	for _, command := range []string{"ls -la", "git status", "find . -name '*.go'", "docker ps -a", "rm notes.txt", "tar -xzf archive.tar.gz"} {
+		if warnings := Analyze(command); len(warnings) != 0 {
+			t.Errorf("Analyze(%q) = %v, want no warnings", command, warnings)
+		}
+	}
+}
+
+func TestAnalyzeOrder(t *testing.T) {
+	warnings := Analyze("chmod 777 run.sh && rm -rf / && git push --force")
+	if len(warnings) == 0 || warnings[0].Rule != "rm-root" {
+		t.Fatalf("warnings = %v, want rm-root first", warnings)
+	}
+	for i := 1; i < len(warnings); i++ {
+		if warnings[i].Severity > warnings[i-1].Severity {
+			t.Errorf("warnings = %v, want the most severe first", warnings)
+		}
+	}
+	// rm -rf inside rm -rf / is covered by the more severe warning
+	if fired(warnings, "rm-recursive-force") {
+		t.Errorf("warnings = %v, want rm-recursive-force dropped", warnings)
+	}
+}
+
+func fired(warnings []Warning, rule string) bool {
+	for _, warning := range warnings {
+		if warning.Rule == rule {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPrintWarnings(t *testing.T) {
+	var out strings.Builder
+	PrintWarnings(&out, Analyze("rm -rf /"))
+	if got := out.String(); got != "Warning [CRITICAL] Deletes the root filesystem or home directory: rm -rf /\n" {
+		t.Errorf("PrintWarnings = %q", got)
+	}
+}
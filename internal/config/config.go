@@ -0,0 +1,349 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	aiutil "github.com/ztkent/ai-util"
+	"github.com/ztkent/moki/internal/providers"
+	"github.com/ztkent/moki/internal/usage"
+	"gopkg.in/yaml.v3"
+)
+
+// Settings are the options that can be set by a flag, env var, config file or profile.
+// Unset fields are left empty, so layers can be merged.
+type Settings struct {
+	Provider    string   `yaml:"llm,omitempty"`
+	Model       string   `yaml:"model,omitempty"`
+	BaseURL     string   `yaml:"base_url,omitempty"`
+	Temperature *float64 `yaml:"temperature,omitempty"`
+	MaxTokens   *int     `yaml:"max_tokens,omitempty"`
+	Resources   *bool    `yaml:"resources,omitempty"`
+	Exec        *bool    `yaml:"exec,omitempty"`
+	Prompt      string   `yaml:"prompt,omitempty"`
+	Output      string   `yaml:"output,omitempty"`
+	NoColor     *bool    `yaml:"no_color,omitempty"`
+	EnvContext  *bool    `yaml:"env_context,omitempty"`
+	EnvFields   []string `yaml:"env_fields,omitempty"`
+	// Truncate is the strategy for resources that don't fit: head, tail, head+tail or relevant
+	Truncate       string `yaml:"truncate,omitempty"`
+	ResourceTokens *int   `yaml:"resource_tokens,omitempty"`
+	// CompactAt is the fraction of the token budget a conversation can fill before it is summarized, 0 disables it
+	CompactAt *float64 `yaml:"compact_at,omitempty"`
+	// Retries is the number of times a failed request is retried, before trying the fallbacks
+	Retries *int `yaml:"retries,omitempty"`
+	// Fallbacks are provider/model pairs, tried in order when a request fails
+	Fallbacks []string `yaml:"fallbacks,omitempty"`
+	// Limits cap the spending, they are merged one limit at a time
+	Limits usage.Limits `yaml:"limits,omitempty"`
+}
+
+// Config is the contents of a config file.
+// Profiles bundle settings under a name, selected with -profile.
+type Config struct {
+	Settings `yaml:",inline"`
+	Profile  string              `yaml:"profile,omitempty"`
+	Profiles map[string]Settings `yaml:"profiles,omitempty"`
+}
+
+const (
+	ProjectConfigName = ".moki.yaml"
+	EnvPrefix         = "MOKI_"
+)
+
+// Defaults are used for any setting that isn't configured.
+func Defaults() Settings {
+	temperature := aiutil.DefaultTemp
+	maxTokens := aiutil.DefaultMaxTokens
+	resources := true
+	exec := false
+	noColor := false
+	envContext := true
+	resourceTokens := 0
+	compactAt := 0.8
+	retries := providers.DefaultRetries
+	return Settings{
+		Provider:    string(aiutil.OpenAI),
+		Output:      "text",
+		Truncate:    "head+tail",
+		Temperature: &temperature,
+		MaxTokens:   &maxTokens,
+		Resources:   &resources,
+		Exec:        &exec,
+		NoColor:     &noColor,
+		EnvContext:  &envContext,
+		// A resource can fill the space left in the conversation
+		ResourceTokens: &resourceTokens,
+		CompactAt:      &compactAt,
+		Retries:        &retries,
+	}
+}
+
+// Merge overrides s with every field that is set in o.
+func (s *Settings) Merge(o Settings) {
+	if o.Provider != "" {
+		s.Provider = o.Provider
+	}
+	if o.Model != "" {
+		s.Model = o.Model
+	}
+	if o.BaseURL != "" {
+		s.BaseURL = o.BaseURL
+	}
+	if o.Temperature != nil {
+		s.Temperature = o.Temperature
+	}
+	if o.MaxTokens != nil {
+		s.MaxTokens = o.MaxTokens
+	}
+	if o.Resources != nil {
+		s.Resources = o.Resources
+	}
+	if o.Exec != nil {
+		s.Exec = o.Exec
+	}
+	if o.Prompt != "" {
+		s.Prompt = o.Prompt
+	}
+	if o.Output != "" {
+		s.Output = o.Output
+	}
+	if o.NoColor != nil {
+		s.NoColor = o.NoColor
+	}
+	if o.EnvContext != nil {
+		s.EnvContext = o.EnvContext
+	}
+	if len(o.EnvFields) > 0 {
+		s.EnvFields = o.EnvFields
+	}
+	if o.Truncate != "" {
+		s.Truncate = o.Truncate
+	}
+	if o.ResourceTokens != nil {
+		s.ResourceTokens = o.ResourceTokens
+	}
+	if o.CompactAt != nil {
+		s.CompactAt = o.CompactAt
+	}
+	if o.Retries != nil {
+		s.Retries = o.Retries
+	}
+	if len(o.Fallbacks) > 0 {
+		s.Fallbacks = o.Fallbacks
+	}
+	s.Limits.Merge(o.Limits)
+}
+
+// Merge overrides c with every setting and profile that is set in o.
+func (c *Config) Merge(o Config) {
+	c.Settings.Merge(o.Settings)
+	if o.Profile != "" {
+		c.Profile = o.Profile
+	}
+	for name, profile := range o.Profiles {
+		if c.Profiles == nil {
+			c.Profiles = map[string]Settings{}
+		}
+		c.Profiles[name] = profile
+	}
+}
+
+// UserConfigPath returns the path of the user config file.
+// $XDG_CONFIG_HOME/moki/config.yaml, or ~/.config/moki/config.yaml if it is unset.
+func UserConfigPath() (string, error) {
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("Failed to find the home directory: %w", err)
+		}
+		configHome = filepath.Join(home, ".config")
+	}
+	return filepath.Join(configHome, "moki", "config.yaml"), nil
+}
+
+// FindProjectConfig searches upward from dir for a .moki.yaml file.
+// It returns an empty path if there is none.
+func FindProjectConfig(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		path := filepath.Join(dir, ProjectConfigName)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// ReadFile parses a YAML config file. A missing file is an empty config.
+func ReadFile(path string) (Config, error) {
+	cfg := Config{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	} else if err != nil {
+		return cfg, fmt.Errorf("Failed to read config %s: %w", path, err)
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("Failed to parse config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Load reads the user config, then the project config found upward from dir.
+// Project settings override user settings.
+func Load(dir string) (Config, error) {
+	cfg := Config{}
+	userPath, err := UserConfigPath()
+	if err != nil {
+		return cfg, err
+	}
+	userConfig, err := ReadFile(userPath)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Merge(userConfig)
+
+	if projectPath := FindProjectConfig(dir); projectPath != "" {
+		projectConfig, err := ReadFile(projectPath)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Merge(projectConfig)
+	}
+	return cfg, nil
+}
+
+// FromEnv reads settings from MOKI_* environment variables.
+func FromEnv() (Settings, error) {
+	s := Settings{
+		Provider: os.Getenv(EnvPrefix + "LLM"),
+		Model:    os.Getenv(EnvPrefix + "MODEL"),
+		BaseURL:  os.Getenv(EnvPrefix + "BASE_URL"),
+		Prompt:   os.Getenv(EnvPrefix + "PROMPT"),
+		Output:   os.Getenv(EnvPrefix + "OUTPUT"),
+		Truncate: os.Getenv(EnvPrefix + "TRUNCATE"),
+	}
+	if v := os.Getenv(EnvPrefix + "TEMPERATURE"); v != "" {
+		temperature, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return s, fmt.Errorf("Invalid %sTEMPERATURE: %s", EnvPrefix, v)
+		}
+		s.Temperature = &temperature
+	}
+	if v := os.Getenv(EnvPrefix + "MAX_TOKENS"); v != "" {
+		maxTokens, err := strconv.Atoi(v)
+		if err != nil {
+			return s, fmt.Errorf("Invalid %sMAX_TOKENS: %s", EnvPrefix, v)
+		}
+		s.MaxTokens = &maxTokens
+	}
+	if v := os.Getenv(EnvPrefix + "RESOURCE_TOKENS"); v != "" {
+		resourceTokens, err := strconv.Atoi(v)
+		if err != nil {
+			return s, fmt.Errorf("Invalid %sRESOURCE_TOKENS: %s", EnvPrefix, v)
+		}
+		s.ResourceTokens = &resourceTokens
+	}
+	if v := os.Getenv(EnvPrefix + "COMPACT_AT"); v != "" {
+		compactAt, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return s, fmt.Errorf("Invalid %sCOMPACT_AT: %s", EnvPrefix, v)
+		}
+		s.CompactAt = &compactAt
+	}
+	if v := os.Getenv(EnvPrefix + "RETRIES"); v != "" {
+		retries, err := strconv.Atoi(v)
+		if err != nil {
+			return s, fmt.Errorf("Invalid %sRETRIES: %s", EnvPrefix, v)
+		}
+		s.Retries = &retries
+	}
+	if v := os.Getenv(EnvPrefix + "ENV_FIELDS"); v != "" {
+		s.EnvFields = strings.Split(v, ",")
+	}
+	if v := os.Getenv(EnvPrefix + "FALLBACKS"); v != "" {
+		s.Fallbacks = strings.Split(v, ",")
+	}
+	for name, field := range map[string]**float64{"LIMIT_DAILY_USD": &s.Limits.DailyUSD, "LIMIT_MONTHLY_USD": &s.Limits.MonthlyUSD, "LIMIT_SESSION_USD": &s.Limits.SessionUSD} {
+		if v := os.Getenv(EnvPrefix + name); v != "" {
+			limit, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return s, fmt.Errorf("Invalid %s%s: %s", EnvPrefix, name, v)
+			}
+			*field = &limit
+		}
+	}
+	for name, field := range map[string]**int{"LIMIT_DAILY_TOKENS": &s.Limits.DailyTokens, "LIMIT_MONTHLY_TOKENS": &s.Limits.MonthlyTokens, "LIMIT_SESSION_TOKENS": &s.Limits.SessionTokens, "LIMIT_RESERVE_TOKENS": &s.Limits.ReserveTokens} {
+		if v := os.Getenv(EnvPrefix + name); v != "" {
+			limit, err := strconv.Atoi(v)
+			if err != nil {
+				return s, fmt.Errorf("Invalid %s%s: %s", EnvPrefix, name, v)
+			}
+			*field = &limit
+		}
+	}
+	for name, field := range map[string]**bool{"RESOURCES": &s.Resources, "EXEC": &s.Exec, "NO_COLOR": &s.NoColor, "ENV_CONTEXT": &s.EnvContext} {
+		if v := os.Getenv(EnvPrefix + name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return s, fmt.Errorf("Invalid %s%s: %s", EnvPrefix, name, v)
+			}
+			*field = &b
+		}
+	}
+	return s, nil
+}
+
+// Resolve layers the settings, from lowest to highest priority:
+// defaults, user config, project config, profile, env vars, flags.
+// The profile is taken from the flag, then MOKI_PROFILE, then the config files.
+func Resolve(cfg Config, profile string, flags Settings) (Settings, error) {
+	settings := Defaults()
+	settings.Merge(cfg.Settings)
+
+	if profile == "" {
+		profile = os.Getenv(EnvPrefix + "PROFILE")
+	}
+	if profile == "" {
+		profile = cfg.Profile
+	}
+	if profile != "" {
+		profileSettings, ok := cfg.Profiles[profile]
+		if !ok {
+			return settings, fmt.Errorf("Unknown profile: %s (available: %s)", profile, strings.Join(cfg.ProfileNames(), ", "))
+		}
+		settings.Merge(profileSettings)
+	}
+
+	env, err := FromEnv()
+	if err != nil {
+		return settings, err
+	}
+	settings.Merge(env)
+	settings.Merge(flags)
+	return settings, nil
+}
+
+// ProfileNames lists the configured profiles.
+func (c Config) ProfileNames() []string {
+	names := []string{}
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
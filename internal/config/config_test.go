@@ -0,0 +1,178 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// clearEnv unsets every MOKI_ env var for the test, so the layers only come from the test.
+func clearEnv(t *testing.T) {
+	t.Helper()
+	for _, kv := range os.Environ() {
+		if name, _, _ := strings.Cut(kv, "="); strings.HasPrefix(name, EnvPrefix) {
+			t.Setenv(name, "")
+		}
+	}
+}
+
+func writeFile(t *testing.T, path string, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindProjectConfig(t *testing.T) {
+	root := t.TempDir()
+	nested := filepath.Join(root, "a", "b", "c")
+	if err := os.MkdirAll(nested, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if path := FindProjectConfig(nested); path != "" && strings.HasPrefix(path, root) {
+		t.Errorf("FindProjectConfig = %s, want none under %s", path, root)
+	}
+
+	writeFile(t, filepath.Join(root, ProjectConfigName), "model: far\n")
+	if path := FindProjectConfig(nested); path != filepath.Join(root, ProjectConfigName) {
+		t.Errorf("FindProjectConfig = %s, want the config in %s", path, root)
+	}
+	// The nearest config wins
+	writeFile(t, filepath.Join(root, "a", ProjectConfigName), "model: near\n")
+	if path := FindProjectConfig(nested); path != filepath.Join(root, "a", ProjectConfigName) {
+		t.Errorf("FindProjectConfig = %s, want the config in %s", path, filepath.Join(root, "a"))
+	}
+	// A directory with the config name isn't a config
+	if err := os.MkdirAll(filepath.Join(nested, ProjectConfigName), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if path := FindProjectConfig(nested); path != filepath.Join(root, "a", ProjectConfigName) {
+		t.Errorf("FindProjectConfig = %s, want the directory skipped", path)
+	}
+}
+
+func TestResolveLayers(t *testing.T) {
+	clearEnv(t)
+	configHome := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+	writeFile(t, filepath.Join(configHome, "moki", "config.yaml"), `
+llm: anthropic
+model: user-model
+base_url: https://user.example.com
+temperature: 0.1
+retries: 1
+output: json
+truncate: head
+limits:
+  daily_usd: 5
+  session_tokens: 1000
+profile: cheap
+profiles:
+  cheap:
+    model: profile-model
+    temperature: 0.2
+    retries: 2
+`)
+	project := t.TempDir()
+	writeFile(t, filepath.Join(project, ProjectConfigName), `
+model: project-model
+temperature: 0.3
+output: raw
+limits:
+  session_tokens: 2000
+`)
+	dir := filepath.Join(project, "src", "pkg")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	t.Setenv(EnvPrefix+"TEMPERATURE", "0.4")
+	t.Setenv(EnvPrefix+"RETRIES", "4")
+	t.Setenv(EnvPrefix+"LIMIT_DAILY_USD", "7")
+	maxTokens := 500
+	retries := 5
+	settings, err := Resolve(cfg, "", Settings{MaxTokens: &maxTokens, Retries: &retries})
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+
+	checks := []struct {
+		name string
+		got  any
+		want any
+	}{
+		{"provider from the user config", settings.Provider, "anthropic"},
+		{"base url from the user config", settings.BaseURL, "https://user.example.com"},
+		{"truncate from the user config", settings.Truncate, "head"},
+		{"output from the project config", settings.Output, "raw"},
+		{"model from the profile", settings.Model, "profile-model"},
+		{"temperature from the env", *settings.Temperature, 0.4},
+		{"daily limit from the env", *settings.Limits.DailyUSD, 7.0},
+		{"session limit from the project config", *settings.Limits.SessionTokens, 2000},
+		{"retries from the flag", *settings.Retries, 5},
+		{"max tokens from the flag", *settings.MaxTokens, 500},
+		{"defaults for the rest", *settings.CompactAt, 0.8},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s = %v, want %v", c.name, c.got, c.want)
+		}
+	}
+}
+
+func TestResolveProfile(t *testing.T) {
+	clearEnv(t)
+	cfg := Config{
+		Profile: "a",
+		Profiles: map[string]Settings{
+			"a": {Model: "model-a"},
+			"b": {Model: "model-b"},
+			"c": {Model: "model-c"},
+		},
+	}
+	tests := []struct {
+		flag string
+		env  string
+		want string
+	}{
+		{"", "", "model-a"},
+		{"", "b", "model-b"},
+		{"c", "b", "model-c"},
+	}
+	for _, tt := range tests {
+		t.Setenv(EnvPrefix+"PROFILE", tt.env)
+		settings, err := Resolve(cfg, tt.flag, Settings{})
+		if err != nil || settings.Model != tt.want {
+			t.Errorf("Resolve with flag %q and env %q = %q, %v, want %q", tt.flag, tt.env, settings.Model, err, tt.want)
+		}
+	}
+
+	if _, err := Resolve(cfg, "missing", Settings{}); err == nil || !strings.Contains(err.Error(), "a, b, c") {
+		t.Errorf("Resolve with an unknown profile = %v, want the available profiles", err)
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	clearEnv(t)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeFile(t, path, "temperature: [")
+	if _, err := ReadFile(path); err == nil {
+		t.Error("ReadFile with invalid YAML succeeded, want an error")
+	}
+	if cfg, err := ReadFile(filepath.Join(t.TempDir(), "missing.yaml")); err != nil || cfg.Model != "" {
+		t.Errorf("ReadFile of a missing file = %+v, %v, want an empty config", cfg, err)
+	}
+
+	t.Setenv(EnvPrefix+"RETRIES", "many")
+	if _, err := FromEnv(); err == nil || !strings.Contains(err.Error(), "MOKI_RETRIES") {
+		t.Errorf("FromEnv = %v, want the invalid env var named", err)
+	}
+}
@@ -0,0 +1,446 @@
+package conversation
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/atotto/clipboard"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/sashabaranov/go-openai"
+	aiutil "github.com/ztkent/ai-util"
+	"github.com/ztkent/moki/internal/providers"
+	"github.com/ztkent/moki/internal/resources"
+	"github.com/ztkent/moki/internal/session"
+)
+
+// Command is a slash command, handled in the chat instead of being sent to the model.
+type Command struct {
+	Name        string
+	Usage       string
+	Description string
+	Run         func(m *MokiModel, args string) (tea.Cmd, error)
+}
+
+var commands = map[string]Command{}
+
+// RegisterCommand adds a slash command to the chat.
+func RegisterCommand(cmd Command) {
+	commands[cmd.Name] = cmd
+}
+
+func init() {
+	for _, cmd := range []Command{
+		{Name: "help", Description: "List the commands and keys", Run: runHelp},
+		{Name: "model", Usage: "[provider] [model]", Description: "Show or switch the provider and model", Run: runModel},
+		{Name: "temp", Usage: "<0-2>", Description: "Set the temperature", Run: runTemp},
+		{Name: "clear", Description: "Start over, keeping the system prompt and environment", Run: runClear},
+		{Name: "save", Usage: "[file]", Description: "Save the session, or export the conversation as markdown", Run: runSave},
+		{Name: "load", Usage: "<id>", Description: "Load a saved session, with the model it was saved with", Run: runLoad},
+		{Name: "system", Usage: "[prompt]", Description: "Show or replace the system prompt", Run: runSystem},
+		{Name: "tokens", Description: "Show the token usage of the conversation", Run: runTokens},
+		{Name: "compact", Description: "Summarize the earlier messages to save tokens", Run: runCompact},
+		{Name: "pin", Description: "Keep the last message and response when the history is compacted", Run: runPin},
+		{Name: "undo", Description: "Remove the last message and response", Run: runUndo},
+		{Name: "retry", Description: "Send the last message again", Run: runRetry},
+		{Name: "copy", Description: "Copy the last response to the clipboard", Run: runCopy},
+		{Name: "exit", Description: "Exit the conversation", Run: runExit},
+	} {
+		RegisterCommand(cmd)
+	}
+}
+
+// commandNames returns the registered commands, sorted by name.
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseCommand splits the input into a registered command and its arguments.
+// Input that isn't a command, like a path, is returned with ok set to false.
+func parseCommand(input string) (cmd Command, args string, ok bool) {
+	if !strings.HasPrefix(input, "/") {
+		return Command{}, "", false
+	}
+	name, args, _ := strings.Cut(input[1:], " ")
+	cmd, ok = commands[strings.ToLower(name)]
+	if !ok && strings.Contains(name, "/") {
+		return Command{}, "", false
+	}
+	return cmd, strings.TrimSpace(args), true
+}
+
+// completeCommand completes a partial command name.
+// The input is extended to the longest common prefix, and every match is returned.
+func completeCommand(input string) (string, []string) {
+	if !strings.HasPrefix(input, "/") || strings.ContainsAny(input, " \n") {
+		return input, nil
+	}
+	matches := []string{}
+	for _, name := range commandNames() {
+		if strings.HasPrefix(name, strings.ToLower(input[1:])) {
+			matches = append(matches, "/"+name)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return input, nil
+	case 1:
+		return matches[0] + " ", matches
+	}
+	prefix := matches[0]
+	for _, match := range matches[1:] {
+		for !strings.HasPrefix(match, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix, matches
+}
+
+func runHelp(m *MokiModel, args string) (tea.Cmd, error) {
+	var b strings.Builder
+	b.WriteString("Commands:\n")
+	for _, name := range commandNames() {
+		cmd := commands[name]
+		fmt.Fprintf(&b, "  %-18s %s\n", strings.TrimSpace("/"+name+" "+cmd.Usage), cmd.Description)
+	}
+	b.WriteString("\nKeys:\n")
+	b.WriteString("  enter              Send the message\n")
+	b.WriteString("  alt+enter, ctrl+j  Insert a new line\n")
+	b.WriteString("  tab                Complete a command\n")
+	b.WriteString("  @                  Add a resource: " + strings.Join(resources.DirectiveTypes, ", ") + "\n")
+	b.WriteString("  pgup, pgdn         Scroll the transcript\n")
+	b.WriteString("  esc, ctrl+c        Cancel the response, or exit")
+	m.notice(b.String())
+	return nil, nil
+}
+
+func runModel(m *MokiModel, args string) (tea.Cmd, error) {
+	fields := strings.Fields(args)
+	switch len(fields) {
+	case 0:
+		return nil, m.showModel()
+	case 1:
+		// A provider on its own switches to its default model
+		if slices.Contains(providers.Providers, aiutil.Provider(strings.ToLower(fields[0]))) {
+			return nil, m.switchModel(strings.ToLower(fields[0]), "")
+		}
+		return nil, m.switchModel(m.client.GetConfig().Provider, fields[0])
+	case 2:
+		return nil, m.switchModel(strings.ToLower(fields[0]), fields[1])
+	}
+	return nil, fmt.Errorf("Usage: /model [provider] [model]")
+}
+
+func (m *MokiModel) showModel() error {
+	config := m.client.GetConfig()
+	temperature := "default"
+	if config.Temperature != nil {
+		temperature = strconv.FormatFloat(*config.Temperature, 'f', -1, 64)
+	}
+	m.notice(fmt.Sprintf("Provider: %s\nModel: %s\nTemperature: %s", config.Provider, config.Model, temperature))
+	return nil
+}
+
+// switchModel rebuilds the client for another provider or model, and carries the conversation over.
+// The conversation has to fit in the new model's context window, and its budget is capped to it.
+func (m *MokiModel) switchModel(provider string, model string) error {
+	opts := []aiutil.Option{aiutil.WithProvider(provider), aiutil.WithModel(model)}
+	if provider != m.client.GetConfig().Provider {
+		// The base URL belongs to the old provider
+		opts = append(opts, aiutil.WithBaseURL(""))
+	}
+	client, overrides, err := m.connect(opts...)
+	if err != nil {
+		return err
+	}
+
+	config := client.GetConfig()
+	budget := m.budget
+	if window, ok := providers.ContextWindow(config.Provider, config.Model); ok {
+		if tokens := tokenCount(m.conv); tokens > window {
+			return fmt.Errorf("The conversation uses %d tokens, more than the %d %s can read. Use /clear or /undo first.", tokens, window, config.Model)
+		}
+		budget = min(budget, window)
+	}
+	m.conv.Lock()
+	m.conv.MaxTokens = budget
+	m.conv.Unlock()
+	m.client, m.overrides = client, overrides
+
+	if m.opts.Session != nil {
+		m.opts.Session.Provider, m.opts.Session.Model = config.Provider, config.Model
+		m.save()
+	}
+	m.notice(fmt.Sprintf("Switched to %s/%s, with a budget of %d tokens.", config.Provider, config.Model, budget))
+	if tokens := tokenCount(m.conv); tokens > budget {
+		m.notice(fmt.Sprintf("The conversation already uses %d tokens. Use /clear or /undo to continue.", tokens))
+	}
+	return nil
+}
+
+func runTemp(m *MokiModel, args string) (tea.Cmd, error) {
+	temperature, err := strconv.ParseFloat(args, 64)
+	if err != nil || temperature < 0 || temperature > 2 {
+		return nil, fmt.Errorf("Usage: /temp <0-2>")
+	}
+	if err := m.reconnect(aiutil.WithTemperature(temperature)); err != nil {
+		return nil, err
+	}
+	m.notice(fmt.Sprintf("Temperature set to %s", args))
+	return nil, nil
+}
+
+func runClear(m *MokiModel, args string) (tea.Cmd, error) {
+	// Resources attached along the way are cleared with the messages they were attached to
+	kept := []openai.ChatCompletionMessage{}
+	for i, msg := range messages(m.conv) {
+		prompt := i == 0 && msg.Role == openai.ChatMessageRoleSystem && !isSummary(msg)
+		if prompt || isEnvironment(msg) {
+			kept = append(kept, msg)
+		}
+	}
+	setMessages(m.conv, kept)
+	m.unpinFrom(0)
+	m.transcript = m.transcript[:1]
+	m.save()
+	m.notice("Conversation cleared.")
+	return nil, nil
+}
+
+func runSave(m *MokiModel, args string) (tea.Cmd, error) {
+	if args == "" {
+		if m.opts.Session == nil {
+			return nil, fmt.Errorf("This conversation has no session, use /save <file> to export it")
+		}
+		if err := m.opts.Session.Save(m.conv); err != nil {
+			return nil, err
+		}
+		m.notice("Saved session " + m.opts.Session.ID)
+		return nil, nil
+	}
+
+	var b strings.Builder
+	for _, msg := range messages(m.conv) {
+		switch msg.Role {
+		case openai.ChatMessageRoleUser:
+			fmt.Fprintf(&b, "## You\n\n%s\n\n", msg.Content)
+		case openai.ChatMessageRoleAssistant:
+			fmt.Fprintf(&b, "## Moki\n\n%s\n\n", msg.Content)
+		}
+	}
+	if err := os.WriteFile(args, []byte(b.String()), 0o644); err != nil {
+		return nil, fmt.Errorf("Failed to export the conversation: %w", err)
+	}
+	m.notice("Exported the conversation to " + args)
+	return nil, nil
+}
+
+func runLoad(m *MokiModel, args string) (tea.Cmd, error) {
+	if args == "" {
+		return nil, fmt.Errorf("Usage: /load <id>")
+	}
+	store, err := session.NewStore("")
+	if err != nil {
+		return nil, err
+	}
+	sess, err := store.Load(args)
+	if err != nil {
+		return nil, err
+	}
+	conv, previous, pinned := m.conv, m.opts.Session, m.pinned
+	m.conv = sess.Conversation()
+	m.opts.Session = sess
+	m.loadPins()
+
+	// The session continues with the model it was saved with, if it still fits
+	config := m.client.GetConfig()
+	notices := len(m.transcript)
+	if sess.Provider != "" && (sess.Provider != config.Provider || sess.Model != config.Model) {
+		if err := m.switchModel(sess.Provider, sess.Model); err != nil {
+			m.conv, m.opts.Session, m.pinned = conv, previous, pinned
+			return nil, fmt.Errorf("Failed to load session %s: %w", sess.ID, err)
+		}
+	}
+	switched := slices.Clone(m.transcript[notices:])
+	if m.opts.Usage != nil {
+		m.opts.Usage.SetSession(sess.ID)
+	}
+	m.tokens = tokenCount(m.conv)
+	m.transcript = m.transcript[:1]
+	m.notice("Loaded session " + sess.ID)
+	m.loadHistory()
+	m.transcript = append(m.transcript, switched...)
+	return nil, nil
+}
+
+func runSystem(m *MokiModel, args string) (tea.Cmd, error) {
+	history := messages(m.conv)
+	added := len(history) == 0 || history[0].Role != openai.ChatMessageRoleSystem
+	if added {
+		history = append([]openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleSystem}}, history...)
+	}
+	if args == "" {
+		m.notice("System prompt:\n" + history[0].Content)
+		return nil, nil
+	}
+	history[0].Content = args
+	setMessages(m.conv, history)
+	if added {
+		// The pinned messages moved down to make room for the prompt
+		pinned := map[int]bool{}
+		for i := range m.pinned {
+			pinned[i+1] = true
+		}
+		m.pinned = pinned
+	}
+	m.save()
+	m.notice("System prompt replaced.")
+	return nil, nil
+}
+
+func runTokens(m *MokiModel, args string) (tea.Cmd, error) {
+	counts := map[string]int{}
+	for _, msg := range messages(m.conv) {
+		tokens, err := aiutil.EstimateMessageTokens(msg)
+		if err != nil {
+			return nil, err
+		}
+		counts[msg.Role] += tokens
+	}
+	total := tokenCount(m.conv)
+	m.notice(fmt.Sprintf("Tokens: %d of %d (%d left)\n  system:    %d\n  user:      %d\n  assistant: %d",
+		total, m.conv.MaxTokens, m.conv.MaxTokens-total,
+		counts[openai.ChatMessageRoleSystem], counts[openai.ChatMessageRoleUser], counts[openai.ChatMessageRoleAssistant]))
+	if limit := CompactionTokens(m.client, m.conv, m.opts.CompactAt); limit > 0 {
+		m.notice(fmt.Sprintf("Earlier messages are summarized at %d tokens.", limit))
+	}
+	if m.opts.Usage != nil {
+		m.notice("Used this session: " + m.opts.Usage.Total().String())
+	}
+	return nil, nil
+}
+
+func runCompact(m *MokiModel, args string) (tea.Cmd, error) {
+	m.notice("Summarizing the earlier messages...")
+	return m.compact(), nil
+}
+
+// runPin pins the last message and its response, or unpins them if they are already pinned.
+func runPin(m *MokiModel, args string) (tea.Cmd, error) {
+	history := messages(m.conv)
+	last := -1
+	for i, msg := range history {
+		if msg.Role == openai.ChatMessageRoleUser {
+			last = i
+		}
+	}
+	if last < 0 {
+		return nil, fmt.Errorf("There is no message to pin")
+	}
+	pin, notice := true, "Pinned the last message and response, they are kept when the history is compacted."
+	if m.pinned[last] {
+		pin, notice = false, "Unpinned the last message and response."
+	}
+	for i := last; i < len(history); i++ {
+		if history[i].Role == openai.ChatMessageRoleSystem {
+			continue
+		}
+		if pin {
+			m.pinned[i] = true
+		} else {
+			delete(m.pinned, i)
+		}
+	}
+	m.save()
+	m.notice(notice)
+	return nil, nil
+}
+
+func runUndo(m *MokiModel, args string) (tea.Cmd, error) {
+	if _, ok := m.removeLastExchange(); !ok {
+		return nil, fmt.Errorf("There is nothing to undo")
+	}
+	m.save()
+	m.notice("Removed the last message.")
+	return nil, nil
+}
+
+func runRetry(m *MokiModel, args string) (tea.Cmd, error) {
+	prompt, ok := m.removeLastExchange()
+	if !ok {
+		return nil, fmt.Errorf("There is nothing to retry")
+	}
+	m.busy = true
+	m.transcript = append(m.transcript, &entry{role: "You", content: prompt})
+	return m.send(prompt), nil
+}
+
+func runCopy(m *MokiModel, args string) (tea.Cmd, error) {
+	history := messages(m.conv)
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Role == openai.ChatMessageRoleAssistant {
+			if err := clipboard.WriteAll(history[i].Content); err != nil {
+				return nil, fmt.Errorf("Failed to copy to the clipboard: %w", err)
+			}
+			m.notice("Copied the last response to the clipboard.")
+			return nil, nil
+		}
+	}
+	return nil, fmt.Errorf("There is no response to copy")
+}
+
+func runExit(m *MokiModel, args string) (tea.Cmd, error) {
+	return tea.Quit, nil
+}
+
+// removeLastExchange removes the last user message, and everything after it, from the conversation and transcript.
+// It returns the removed message.
+func (m *MokiModel) removeLastExchange() (string, bool) {
+	history := messages(m.conv)
+	last := -1
+	for i, msg := range history {
+		if msg.Role == openai.ChatMessageRoleUser {
+			last = i
+		}
+	}
+	if last < 0 {
+		return "", false
+	}
+	setMessages(m.conv, history[:last])
+	m.unpinFrom(last)
+	for i := len(m.transcript) - 1; i > 0; i-- {
+		if m.transcript[i].role == "You" {
+			m.transcript = m.transcript[:i]
+			break
+		}
+	}
+	return history[last].Content, true
+}
+
+// messages returns a copy of the conversation history.
+func messages(conv *aiutil.Conversation) []openai.ChatCompletionMessage {
+	conv.Lock()
+	defer conv.Unlock()
+	return append([]openai.ChatCompletionMessage{}, conv.Messages...)
+}
+
+// setMessages replaces the conversation history, and recounts its tokens.
+func setMessages(conv *aiutil.Conversation, history []openai.ChatCompletionMessage) {
+	conv.Lock()
+	defer conv.Unlock()
+	conv.Messages = history
+	conv.TokenCount = 0
+	for _, msg := range history {
+		if tokens, err := aiutil.EstimateMessageTokens(msg); err == nil {
+			conv.TokenCount += tokens
+		}
+	}
+}
@@ -3,15 +3,16 @@ package conversation
 import (
 	"context"
 	"fmt"
-	"slices"
-	"strings"
 	"time"
 
-	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/sashabaranov/go-openai"
 	aiutil "github.com/ztkent/ai-util"
 	"github.com/ztkent/moki/internal/prompts"
+	"github.com/ztkent/moki/internal/render"
+	"github.com/ztkent/moki/internal/session"
 	"github.com/ztkent/moki/internal/tools"
+	"github.com/ztkent/moki/internal/usage"
 )
 
 const (
@@ -26,60 +27,90 @@ const (
 
 var exitCommands = []string{"exit", "quit", ":q!"}
 
+// Options configure a conversation.
+type Options struct {
+	// Session saves the conversation after every turn, if set.
+	// A session with history is resumed, instead of starting with an introduction.
+	Session *session.Session
+	// Markdown renders responses in the terminal, instead of printing raw markdown.
+	Markdown bool
+	// NewClient creates a client from the starting options, with the changes made during the conversation.
+	// Without it, the client can't be changed.
+	NewClient func(opts ...aiutil.Option) (aiutil.Client, error)
+	// MaxTokens is the token budget of the conversation, before it is capped to a model's context window.
+	// If it is 0, the budget of the conversation is used.
+	MaxTokens int
+	// Usage adds up the tokens and cost of the conversation, if set.
+	Usage *usage.Tracker
+	// Resources sets how the resources in a message are attached.
+	Resources tools.ResourceOptions
+	// CompactAt is the fraction of the token budget or context window the history can fill, before older messages are summarized.
+	// If it is 0, the history is only compacted with /compact.
+	CompactAt float64
+}
+
 // StartConversationCLI starts a conversation with Moki via the CLI
-func StartConversationCLI(client aiutil.Client, conv *aiutil.Conversation) error {
+func StartConversationCLI(client aiutil.Client, conv *aiutil.Conversation, opts Options) error {
 	ctx, cancel := context.WithTimeout(context.Background(), MaxConversationTime)
 	defer cancel()
 
-	fmt.Print(MokiHeader + "\n\n")
-	introChat, err := GetIntroduction(client, ctx)
-	if err != nil {
+	if err := StartChat(ctx, client, conv, opts); err != nil {
 		return err
 	}
-	fmt.Println("Moki: " + introChat)
+	fmt.Println("Goodbye!")
+	if opts.Usage != nil && opts.Usage.Total().Tokens() > 0 {
+		fmt.Printf("Used %s.\n", opts.Usage.Total())
+	}
+	if opts.Session != nil && len(opts.Session.Messages) > 1 {
+		fmt.Printf("Resume this conversation with: moki -c -resume %s\n", opts.Session.ID)
+	}
+	return nil
+}
+
+// PrintHistory prints the user and assistant messages of a conversation.
+func PrintHistory(conv *aiutil.Conversation, markdown bool) {
+	for _, m := range conv.Messages {
+		switch m.Role {
+		case openai.ChatMessageRoleUser:
+			fmt.Println("You: " + m.Content)
+		case openai.ChatMessageRoleAssistant:
+			printMessage("Moki", m.Content, markdown)
+		}
+	}
+}
 
-	return StartChat(ctx, client, conv)
+// printMessage prints a complete message, rendering it if markdown is enabled.
+func printMessage(name string, content string, markdown bool) {
+	if markdown {
+		if rendered, err := render.Markdown(content, render.Width()); err == nil {
+			fmt.Println(name + ":\n" + rendered)
+			return
+		}
+	}
+	fmt.Println(name + ": " + content)
 }
 
 // StartChat starts a chat session with Moki
-// It handles user input and manages the conversation flow.
-func StartChat(ctx context.Context, client aiutil.Client, conv *aiutil.Conversation) error {
-	for {
-		done, err := func() (bool, error) {
-			textInput := textinput.New()
-			textInput.Prompt = "You: "
-			m := MokiModel{Model: textInput, quit: false}
-			m.Model.Focus()
-			p := tea.NewProgram(m)
-			if resModel, err := p.Run(); err != nil {
-				return true, err
-			} else if resModel == nil {
-				return true, fmt.Errorf("failed to continue the conversation.")
-			} else {
-				m = resModel.(MokiModel)
-				if m.quit {
-					fmt.Println("Goodbye!")
-					return true, nil
-				}
-				fmt.Println("You: " + m.Value())
-			}
-			// Handle user's message
-			shouldExit, err := HandleUserMessage(client, conv, ctx, m.Value())
-			if shouldExit {
-				return true, nil
-			}
-			if err != nil {
-				return false, err
-			}
-			return false, nil
-		}()
-		if err != nil {
-			fmt.Println("Request Failed: ", err)
-		}
-		if done {
-			break
+// A single full screen program handles user input and streams the responses, until the user exits.
+func StartChat(ctx context.Context, client aiutil.Client, conv *aiutil.Conversation, opts Options) error {
+	if opts.Markdown {
+		// Resolve the style before the program starts reading from the terminal
+		if _, err := render.NewRenderer(render.Width()); err != nil {
+			return err
 		}
 	}
+	var p *tea.Program
+	// Commands from -cmd: resources are confirmed in the chat, while the resources are added in the background
+	opts.Resources.Confirm = func(command string) (bool, error) {
+		reply := make(chan bool, 1)
+		p.Send(confirmCommandMsg{command: command, reply: reply})
+		return <-reply, nil
+	}
+	m := NewMokiModel(ctx, client, conv, opts)
+	p = tea.NewProgram(m, tea.WithAltScreen(), tea.WithMouseCellMotion())
+	if _, err := p.Run(); err != nil {
+		return fmt.Errorf("Failed to continue the conversation: %w", err)
+	}
 	return nil
 }
 
@@ -94,49 +125,3 @@ func GetIntroduction(client aiutil.Client, ctx context.Context) (string, error)
 	}
 	return introChat, err
 }
-
-// HandleUserMessage handles the user's message and returns true if the user wants to exit.
-func HandleUserMessage(client aiutil.Client, conv *aiutil.Conversation, ctx context.Context, userInput string) (bool, error) {
-	modifiedInput, resourcesAdded, err := tools.ManageResources(conv, userInput)
-	if err != nil {
-		return false, err
-	}
-
-	if slices.Contains(exitCommands, strings.ToLower(strings.TrimSpace(modifiedInput))) {
-		fmt.Println("Goodbye!")
-		return true, nil
-	}
-
-	if len(modifiedInput) == 0 && len(resourcesAdded) == 0 {
-		fmt.Println("Please provide a message or command to continue the conversation.")
-		return false, nil
-	} else if len(resourcesAdded) > 0 {
-		fmt.Println("Resources added to conversation: ", strings.Join(resourcesAdded, ","))
-	}
-
-	ctxWithTimeout, cancel := context.WithTimeout(ctx, SingleRequestTime)
-	defer cancel()
-
-	responseChan, errChan := make(chan string), make(chan error)
-	go client.SendStreamRequest(ctxWithTimeout, conv, modifiedInput, responseChan, errChan)
-
-	firstResponse := true
-	for {
-		select {
-		case response, ok := <-responseChan:
-			if !ok {
-				return false, nil
-			}
-			if firstResponse {
-				fmt.Print("Moki: ")
-				defer fmt.Println()
-				firstResponse = false
-			}
-			fmt.Print(response)
-		case err := <-errChan:
-			if err != nil {
-				return false, err
-			}
-		}
-	}
-}
@@ -0,0 +1,146 @@
+package conversation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	aiutil "github.com/ztkent/ai-util"
+	"github.com/ztkent/moki/internal/environment"
+	"github.com/ztkent/moki/internal/prompts"
+	"github.com/ztkent/moki/internal/providers"
+	"github.com/ztkent/moki/internal/tokenizer"
+)
+
+const (
+	// KeepTurns are the most recent exchanges kept verbatim when the history is compacted.
+	KeepTurns = 2
+	// CompactTime is the longest the summary can take.
+	CompactTime = time.Minute * 2
+	// summaryHeader starts the system message that replaces the compacted messages.
+	summaryHeader = "Summary of the earlier conversation:\n\n"
+)
+
+// Compaction describes the messages that were replaced by a summary.
+type Compaction struct {
+	Messages int
+	Before   int
+	After    int
+	// Pinned are the indexes of the pinned messages in the compacted history
+	Pinned map[int]bool
+}
+
+func (c *Compaction) String() string {
+	return fmt.Sprintf("Compacted %d messages into a summary, from %s to %s tokens.", c.Messages, tokenizer.Format(c.Before), tokenizer.Format(c.After))
+}
+
+// CompactionTokens is the size of the history that is compacted, a fraction of the token budget
+// or the model's context window, whichever is smaller. A fraction of 0 is never compacted.
+func CompactionTokens(client aiutil.Client, conv *aiutil.Conversation, at float64) int {
+	if at <= 0 {
+		return 0
+	}
+	conv.Lock()
+	limit := conv.MaxTokens
+	conv.Unlock()
+	config := client.GetConfig()
+	if window, ok := providers.ContextWindow(config.Provider, config.Model); ok {
+		limit = min(limit, window)
+	}
+	return int(at * float64(limit))
+}
+
+// NeedsCompaction reports whether the history fills more than the fraction of the token budget or context window.
+func NeedsCompaction(client aiutil.Client, conv *aiutil.Conversation, at float64) bool {
+	limit := CompactionTokens(client, conv, at)
+	return limit > 0 && tokenCount(conv) >= limit
+}
+
+// Compact summarizes the older messages of the conversation with a separate request, and replaces them with the summary.
+// System messages, like the prompt, environment and resources, and the pinned messages are kept verbatim,
+// along with the last keepTurns exchanges. An earlier summary is merged into the new one.
+// Pinned holds the indexes of the pinned messages, the Compaction has their indexes after the summary.
+func Compact(ctx context.Context, client aiutil.Client, conv *aiutil.Conversation, keepTurns int, pinned map[int]bool) (*Compaction, error) {
+	history := messages(conv)
+	before := tokenCount(conv)
+
+	// The recent exchanges start at the last user messages
+	recent := len(history)
+	for i, turns := len(history)-1, 0; i >= 0 && turns < keepTurns; i-- {
+		if history[i].Role == openai.ChatMessageRoleUser {
+			recent, turns = i, turns+1
+		}
+	}
+
+	var transcript strings.Builder
+	kept := []openai.ChatCompletionMessage{}
+	keptPinned := []bool{}
+	summaryAt, compacted := -1, 0
+	for i, msg := range history[:recent] {
+		switch {
+		case isSummary(msg):
+			fmt.Fprintf(&transcript, "Earlier summary:\n%s\n\n", strings.TrimPrefix(msg.Content, summaryHeader))
+		case msg.Role == openai.ChatMessageRoleSystem || pinned[i]:
+			kept = append(kept, msg)
+			keptPinned = append(keptPinned, pinned[i])
+			continue
+		case msg.Role == openai.ChatMessageRoleUser:
+			fmt.Fprintf(&transcript, "User: %s\n\n", msg.Content)
+			compacted++
+		default:
+			fmt.Fprintf(&transcript, "Assistant: %s\n\n", msg.Content)
+			compacted++
+		}
+		// The summary takes the place of the first message it replaces
+		if summaryAt < 0 {
+			summaryAt = len(kept)
+		}
+	}
+	if compacted == 0 {
+		return nil, fmt.Errorf("There is nothing to compact, the last %d exchanges and pinned messages are always kept", keepTurns)
+	}
+
+	summaryConv := aiutil.NewConversation(prompts.CompactPrompt, conv.MaxTokens, false)
+	summary, err := client.SendCompletionRequest(ctx, summaryConv, transcript.String())
+	if err != nil {
+		return nil, fmt.Errorf("Failed to summarize the conversation: %w", err)
+	}
+	summary = strings.TrimSpace(summary)
+	if summary == "" {
+		return nil, fmt.Errorf("Failed to summarize the conversation: the summary was empty")
+	}
+
+	compact := append([]openai.ChatCompletionMessage{}, kept[:summaryAt]...)
+	compact = append(compact, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: summaryHeader + summary})
+	compact = append(compact, kept[summaryAt:]...)
+	compact = append(compact, history[recent:]...)
+	setMessages(conv, compact)
+
+	// The kept messages after the summary, and the recent ones, move to make room for it
+	compactPinned := map[int]bool{}
+	for i, isPinned := range keptPinned {
+		if isPinned && i < summaryAt {
+			compactPinned[i] = true
+		} else if isPinned {
+			compactPinned[i+1] = true
+		}
+	}
+	for i := recent; i < len(history); i++ {
+		if pinned[i] {
+			compactPinned[len(kept)+1+i-recent] = true
+		}
+	}
+	return &Compaction{Messages: compacted, Before: before, After: tokenCount(conv), Pinned: compactPinned}, nil
+}
+
+// isEnvironment reports whether the message is the user's environment, attached when the conversation started.
+func isEnvironment(msg openai.ChatCompletionMessage) bool {
+	return msg.Role == openai.ChatMessageRoleSystem && strings.HasPrefix(msg.Content, fmt.Sprintf("<Reference id=%q>", environment.ReferenceID))
+}
+
+// isSummary reports whether the message is the summary of compacted messages.
+func isSummary(msg openai.ChatCompletionMessage) bool {
+	return msg.Role == openai.ChatMessageRoleSystem && strings.HasPrefix(msg.Content, summaryHeader)
+}
@@ -2,148 +2,82 @@ package conversation
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/ztkent/moki/internal/resources"
 )
 
-type ResourceSelectionModel struct {
-	resourceTypes []string
-	cursor        int
-	selected      bool
-	quit          bool
-	textinput.Model
+// resourcePicker selects a resource type, and then its path, without leaving the chat.
+type resourcePicker struct {
+	cursor   int
+	selected bool
+	input    textinput.Model
 }
 
-func (m ResourceSelectionModel) Init() tea.Cmd {
-	return nil
-}
-func (m ResourceSelectionModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
-	switch msg := msg.(type) {
-	case tea.KeyMsg:
-		switch key := msg.String(); key {
-		case "ctrl+c", "esc", "\x1b":
-			m.quit = true
-			return m, tea.Quit
-		case "enter", "\r":
-			m.selected = true
-			return m, tea.Quit
-		case "down", "\x1b[B":
-			if m.cursor < len(m.resourceTypes)-1 {
-				m.cursor++
-			}
-		case "up", "\x1b[A":
-			if m.cursor > 0 {
-				m.cursor--
-			}
-		}
-	}
-	return m, nil
-}
-
-func (m ResourceSelectionModel) View() string {
-	if m.selected || m.quit {
-		return ""
-	}
-	view := ""
-	for i, resourceType := range m.resourceTypes {
-		cursor := " "
-		if m.cursor == i {
-			cursor = ">"
-		}
-		view += fmt.Sprintf("%s %s\n", cursor, resourceType)
-	}
-	return view
+// resourceSelectedMsg is sent when the picker closes.
+// The directive is empty if the selection was cancelled.
+type resourceSelectedMsg struct {
+	directive string
 }
 
-type ResourceInputModel struct {
-	textinput.Model
-	inputType string
-	finished  bool
+func newResourcePicker() resourcePicker {
+	return resourcePicker{input: textinput.New()}
 }
 
-func (m ResourceInputModel) Init() tea.Cmd {
-	return nil
-}
-
-func (m ResourceInputModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
-	switch msg := msg.(type) {
-	case tea.KeyMsg:
-		switch msg.String() {
-		case "ctrl+c", "esc", "\x1b":
-			return m, tea.Quit
-		case "enter", "\r":
-			m.finished = true
-			return m, tea.Quit
-		default:
-			// Let the text input handle all other key presses
-			updatedModel, cmd := m.Model.Update(msg)
-			m.Model = updatedModel
-			return m, cmd
+func (p resourcePicker) Update(msg tea.KeyMsg) (resourcePicker, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c", "esc":
+		return p, selectResource("")
+	case "enter":
+		if !p.selected {
+			p.selected = true
+			p.input.Prompt = resources.DirectiveTypes[p.cursor] + ": "
+			return p, p.input.Focus()
 		}
+		path := strings.TrimSpace(p.input.Value())
+		if path == "" {
+			return p, selectResource("")
+		}
+		return p, selectResource(resources.FormatDirective(resources.DirectiveTypes[p.cursor], path))
 	}
-	return m, nil
-}
-
-func (m ResourceInputModel) View() string {
-	if m.finished {
-		return ""
-	}
-	return m.Model.View()
-}
 
-func ManageResourceSelection(userInput string) (string, error) {
-	// Select the type of resource to input
-	resourceType, err := getResourceType(userInput)
-	if err != nil {
-		return userInput, err
-	} else if resourceType == "" {
-		return userInput, nil
+	if p.selected {
+		var cmd tea.Cmd
+		p.input, cmd = p.input.Update(msg)
+		return p, cmd
 	}
-
-	// Get the resource path
-	resourcePath, err := getResourcePath(resourceType)
-	if err != nil {
-		return userInput, err
-	} else if resourcePath == "" {
-		return userInput, nil
+	switch msg.String() {
+	case "down", "tab":
+		if p.cursor < len(resources.DirectiveTypes)-1 {
+			p.cursor++
+		}
+	case "up", "shift+tab":
+		if p.cursor > 0 {
+			p.cursor--
+		}
 	}
-
-	// Add the resource to the user's input
-	return userInput + " -" + resourceType + ":" + resourcePath, nil
+	return p, nil
 }
 
-func getResourcePath(resourceType string) (string, error) {
-	m := ResourceInputModel{inputType: resourceType, Model: textinput.New()}
-	m.Focus()
-	defer m.Blur()
-	m.Prompt = resourceType + ": "
-	p := tea.NewProgram(m)
-	defer p.RestoreTerminal()
-	if resModel, err := p.Run(); err != nil {
-		return "", err
-	} else {
-		if !resModel.(ResourceInputModel).finished {
-			return "", nil
+func (p resourcePicker) View() string {
+	if p.selected {
+		return p.input.View()
+	}
+	view := "Add a resource (enter to select, esc to cancel)\n"
+	for i, resourceType := range resources.DirectiveTypes {
+		cursor := " "
+		if p.cursor == i {
+			cursor = ">"
 		}
-		m = resModel.(ResourceInputModel)
+		view += fmt.Sprintf("%s %s\n", cursor, resourceType)
 	}
-	return m.Value(), nil
+	return strings.TrimSuffix(view, "\n")
 }
 
-func getResourceType(userInput string) (string, error) {
-	m := ResourceSelectionModel{resourceTypes: []string{"url", "file"}, Model: textinput.New()}
-	m.Focus()
-	defer m.Blur()
-	p := tea.NewProgram(m)
-	defer p.RestoreTerminal()
-	if resModel, err := p.Run(); err != nil {
-		return userInput, err
-	} else {
-		if !resModel.(ResourceSelectionModel).selected {
-			return "", nil
-		}
-		m = resModel.(ResourceSelectionModel)
+func selectResource(directive string) tea.Cmd {
+	return func() tea.Msg {
+		return resourceSelectedMsg{directive: directive}
 	}
-	return m.resourceTypes[m.cursor], nil
 }
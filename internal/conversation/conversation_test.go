@@ -0,0 +1,307 @@
+package conversation
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/sashabaranov/go-openai"
+	aiutil "github.com/ztkent/ai-util"
+	"github.com/ztkent/moki/internal/environment"
+	"github.com/ztkent/moki/internal/providers"
+	"github.com/ztkent/moki/internal/resources"
+	"github.com/ztkent/moki/internal/session"
+)
+
+// newChat starts a chat with a mock client, past its introduction.
+func newChat(t *testing.T, opts Options, responses ...providers.MockResponse) MokiModel {
+	t.Helper()
+	client := providers.MustMockClient("mock-1", responses...)
+	conv := aiutil.NewConversation("system", 10000, true)
+	var m tea.Model = NewMokiModel(context.Background(), client, conv, opts)
+	m, _ = m.Update(tea.WindowSizeMsg{Width: 100, Height: 40})
+	m, _ = m.Update(introMsg{text: "Hello"})
+	return m.(MokiModel)
+}
+
+// run feeds the messages of cmd back into the chat, until it has nothing left to do.
+func run(m tea.Model, cmd tea.Cmd) MokiModel {
+	for cmd != nil {
+		msg := cmd()
+		if batch, ok := msg.(tea.BatchMsg); ok {
+			for _, c := range batch {
+				m = run(m, c)
+			}
+			return m.(MokiModel)
+		}
+		m, cmd = m.Update(msg)
+	}
+	return m.(MokiModel)
+}
+
+// say types the input into the chat and waits for the response.
+func say(m MokiModel, input string) MokiModel {
+	m.input.SetValue(input)
+	model, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	return run(model, cmd)
+}
+
+func roles(conv *aiutil.Conversation) string {
+	r := []string{}
+	for _, msg := range messages(conv) {
+		r = append(r, msg.Role)
+	}
+	return strings.Join(r, ",")
+}
+
+func notices(m MokiModel) string {
+	var b strings.Builder
+	for _, e := range m.transcript {
+		if e.role == "" {
+			b.WriteString(e.content + "\n")
+		}
+	}
+	return b.String()
+}
+
+func TestChat(t *testing.T) {
+	m := newChat(t, Options{}, providers.MockResponse{Text: "first answer"}, providers.MockResponse{Text: "second answer"})
+	m = say(m, "first question")
+	m = say(m, "second question")
+	if m.busy {
+		t.Error("still busy after the responses")
+	}
+	if got := roles(m.conv); got != "system,user,assistant,user,assistant" {
+		t.Errorf("roles = %s", got)
+	}
+	last := m.transcript[len(m.transcript)-1]
+	if last.role != "Moki" || last.content != "second answer" {
+		t.Errorf("last entry = %+v, want the second answer", last)
+	}
+	if m.tokens != tokenCount(m.conv) {
+		t.Errorf("tokens = %d, want %d", m.tokens, tokenCount(m.conv))
+	}
+}
+
+func TestChatUndoAndRetry(t *testing.T) {
+	m := newChat(t, Options{}, providers.MockResponse{Text: "first"}, providers.MockResponse{Text: "second"}, providers.MockResponse{Text: "retried"})
+	m = say(m, "one")
+	m = say(m, "two")
+	m = say(m, "/undo")
+	if got := roles(m.conv); got != "system,user,assistant" {
+		t.Errorf("roles after /undo = %s", got)
+	}
+	m = say(m, "/retry")
+	history := messages(m.conv)
+	if len(history) != 3 || history[1].Content != "one" || history[2].Content != "retried" {
+		t.Errorf("messages after /retry = %v, want the first question answered again", history)
+	}
+}
+
+func TestChatError(t *testing.T) {
+	m := newChat(t, Options{}, providers.MockResponse{Status: 400, Error: "bad request"})
+	m = say(m, "question")
+	if got := roles(m.conv); got != "system" {
+		t.Errorf("roles = %s, want the failed prompt removed", got)
+	}
+	if !strings.Contains(notices(m), "Request Failed") || !strings.Contains(notices(m), "status 400") {
+		t.Errorf("notices = %q, want the error", notices(m))
+	}
+	if m.busy {
+		t.Error("still busy after the error")
+	}
+}
+
+func TestChatCancel(t *testing.T) {
+	m := newChat(t, Options{}, providers.MockResponse{Chunks: []string{"partial", " answer"}, Delay: "1m"})
+	m.input.SetValue("question")
+	model, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	// Cancel once the stream has started, before the first chunk
+	model, cmd = model.Update(cmd())
+	model, _ = model.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	m = run(model, cmd)
+	if m.busy || !strings.Contains(notices(m), "Response cancelled.") {
+		t.Errorf("busy = %v, notices = %q, want the response cancelled", m.busy, notices(m))
+	}
+
+	// Without a request to cancel, esc quits
+	if _, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEsc}); cmd == nil || cmd() != tea.Quit() {
+		t.Error("esc didn't quit the idle chat")
+	}
+}
+
+func TestChatSession(t *testing.T) {
+	store, err := session.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess, err := store.New(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newChat(t, Options{Session: sess}, providers.MockResponse{Text: "answer"})
+	m = say(m, "question")
+	m = say(m, "/pin")
+
+	saved, err := store.Load(sess.ID)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(saved.Messages) != 3 || saved.Messages[2].Content != "answer" {
+		t.Errorf("saved messages = %v, want the exchange", saved.Messages)
+	}
+	if len(saved.Pinned) != 2 || saved.Pinned[0] != 1 || saved.Pinned[1] != 2 {
+		t.Errorf("saved pins = %v, want the exchange", saved.Pinned)
+	}
+	for _, msg := range saved.Messages {
+		if msg.Name != "" {
+			t.Errorf("message %q has the name %q, pins are kept out of the messages", msg.Content, msg.Name)
+		}
+	}
+
+	// The pins are restored with the session
+	resumed := NewMokiModel(context.Background(), m.client, saved.Conversation(), Options{Session: saved})
+	if !resumed.pinned[1] || !resumed.pinned[2] {
+		t.Errorf("resumed pins = %v, want the exchange", resumed.pinned)
+	}
+	m = say(m, "/pin")
+	if len(m.pinned) != 0 {
+		t.Errorf("pins after a second /pin = %v, want none", m.pinned)
+	}
+}
+
+func TestChatPinsCompaction(t *testing.T) {
+	m := newChat(t, Options{},
+		providers.MockResponse{Match: "^q[0-9]$", Text: "answer"},
+		providers.MockResponse{Match: "User: q0", Text: "the summary"},
+	)
+	for _, q := range []string{"q0", "q1"} {
+		m = say(m, q)
+	}
+	m = say(m, "/pin")
+	for _, q := range []string{"q2", "q3", "q4"} {
+		m = say(m, q)
+	}
+	m = say(m, "/compact")
+
+	history := messages(m.conv)
+	if got := roles(m.conv); got != "system,system,user,assistant,user,assistant,user,assistant" {
+		t.Fatalf("roles after compaction = %s, want the summary, the pinned exchange and the last 2 exchanges", got)
+	}
+	if !isSummary(history[1]) || !strings.Contains(history[1].Content, "the summary") {
+		t.Errorf("message 1 = %q, want the summary", history[1].Content)
+	}
+	if history[2].Content != "q1" || !m.pinned[2] || !m.pinned[3] || len(m.pinned) != 2 {
+		t.Errorf("pins = %v on %q, want the pinned exchange to follow it", m.pinned, history[2].Content)
+	}
+
+	// Pins that are removed with their messages are dropped
+	m = say(m, "/clear")
+	if got := roles(m.conv); got != "system" || len(m.pinned) != 0 {
+		t.Errorf("roles = %s, pins = %v after /clear, want neither", got, m.pinned)
+	}
+}
+
+func TestChatCommands(t *testing.T) {
+	m := newChat(t, Options{})
+	m = say(m, "/system be brief")
+	if history := messages(m.conv); history[0].Role != openai.ChatMessageRoleSystem || history[0].Content != "be brief" {
+		t.Errorf("system prompt = %q, want it replaced", history[0].Content)
+	}
+	m = say(m, "/unknown")
+	if !strings.Contains(notices(m), "unknown") {
+		t.Errorf("notices = %q, want the unknown command reported", notices(m))
+	}
+	// A path isn't a command, so it is sent as a message
+	m = say(m, "/usr/bin/env")
+	if history := messages(m.conv); history[len(history)-1].Content != "/usr/bin/env" {
+		t.Errorf("last message = %q, want the path echoed", history[len(history)-1].Content)
+	}
+}
+
+func TestChatLoad(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	store, err := session.NewStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := aiutil.NewConversation("saved prompt", 10000, true)
+	saved.Append(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: "saved question"})
+	saved.Append(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: "saved answer"})
+	sess, err := store.New(nil, saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess.Provider, sess.Model = string(providers.Mock), "mock-2"
+	if err := store.Save(sess); err != nil {
+		t.Fatal(err)
+	}
+
+	connected := []string{}
+	newClient := func(opts ...aiutil.Option) (aiutil.Client, error) {
+		config := aiutil.ClientConfig{Provider: string(providers.Mock), Model: "mock-1"}
+		for _, opt := range opts {
+			opt(&config)
+		}
+		connected = append(connected, config.Model)
+		return providers.NewMockClient(config, providers.MockFixture{})
+	}
+	m := newChat(t, Options{NewClient: newClient})
+	m = say(m, "/load "+sess.ID)
+	if got := m.client.GetConfig().Model; got != "mock-2" || len(connected) != 1 {
+		t.Errorf("model = %s after connecting %v, want the session's model", got, connected)
+	}
+	if history := messages(m.conv); len(history) != 3 || history[2].Content != "saved answer" {
+		t.Errorf("messages = %v, want the saved conversation", history)
+	}
+	if !strings.Contains(notices(m), "Loaded session "+sess.ID) || !strings.Contains(notices(m), "Switched to mock/mock-2") {
+		t.Errorf("notices = %q, want the session loaded and the model switched", notices(m))
+	}
+
+	// A session whose model can't be connected isn't loaded
+	failing := newChat(t, Options{NewClient: func(opts ...aiutil.Option) (aiutil.Client, error) {
+		return nil, errors.New("no API key")
+	}})
+	failing = say(failing, "/load "+sess.ID)
+	if got := roles(failing.conv); got != "system" || failing.opts.Session != nil {
+		t.Errorf("roles = %s, session = %v, want the current conversation kept", got, failing.opts.Session)
+	}
+	if !strings.Contains(notices(failing), "no API key") {
+		t.Errorf("notices = %q, want the connection error", notices(failing))
+	}
+}
+
+func TestChatClear(t *testing.T) {
+	m := newChat(t, Options{}, providers.MockResponse{Text: "answer"})
+	m.conv.AddReference(environment.ReferenceID, "OS: linux")
+	m = say(m, "question")
+	m.conv.AddReference("file:notes.txt", "remember the milk")
+	m = say(m, "/clear")
+
+	history := messages(m.conv)
+	if len(history) != 2 || history[0].Content != "system" || !isEnvironment(history[1]) {
+		t.Errorf("messages after /clear = %v, want the system prompt and environment", history)
+	}
+}
+
+func TestResourcePicker(t *testing.T) {
+	p := newResourcePicker()
+	for _, resourceType := range resources.DirectiveTypes {
+		if !strings.Contains(p.View()+"\n", " "+resourceType+"\n") {
+			t.Errorf("picker = %q, want %s offered", p.View(), resourceType)
+		}
+	}
+
+	// Pick the last type, and type its value
+	for range resources.DirectiveTypes {
+		p, _ = p.Update(tea.KeyMsg{Type: tea.KeyDown})
+	}
+	p, _ = p.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	p.input.SetValue("git status")
+	_, cmd := p.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	if msg, ok := cmd().(resourceSelectedMsg); !ok || msg.directive != `-cmd:"git status"` {
+		t.Errorf("selected %v, want the cmd directive", cmd())
+	}
+}
@@ -1,70 +1,688 @@
 package conversation
 
 import (
-	"time"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
 
-	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textarea"
+	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/glamour"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/sashabaranov/go-openai"
+	aiutil "github.com/ztkent/ai-util"
+	"github.com/ztkent/moki/internal/analyzer"
+	"github.com/ztkent/moki/internal/render"
+	"github.com/ztkent/moki/internal/tools"
 )
 
+const (
+	maxInputHeight = 8
+	// InterruptedMarker ends a partial answer that was cancelled.
+	InterruptedMarker = "[interrupted]"
+)
+
+var (
+	labelStyle  = lipgloss.NewStyle().Bold(true)
+	noticeStyle = lipgloss.NewStyle().Faint(true)
+	statusStyle = lipgloss.NewStyle().Reverse(true)
+)
+
+// entry is a single message in the transcript.
+// Notices, like errors and warnings, have no role.
+type entry struct {
+	role     string
+	content  string
+	rendered string
+	width    int
+}
+
+type introMsg struct {
+	text string
+	err  error
+}
+
+type streamStartedMsg struct {
+	prompt       string
+	resources    []string
+	warnings     []string
+	responseChan chan string
+	errChan      chan error
+	cancel       context.CancelFunc
+}
+
+type streamChunkMsg string
+
+type streamDoneMsg struct{}
+
+type streamErrMsg struct {
+	err error
+}
+
+type compactDoneMsg struct {
+	compaction *Compaction
+	err        error
+}
+
+// confirmCommandMsg asks the user before a -cmd: resource is run.
+// The answer is sent on reply.
+type confirmCommandMsg struct {
+	command string
+	reply   chan bool
+}
+
+// MokiModel is the full screen chat.
+// The transcript is shown in a scrollable viewport, above a status bar and a multi-line input.
 type MokiModel struct {
-	textinput.Model
-	quit              bool
-	selectingResource bool
+	ctx    context.Context
+	client aiutil.Client
+	conv   *aiutil.Conversation
+	opts   Options
+
+	viewport   viewport.Model
+	input      textarea.Model
+	picker     *resourcePicker
+	confirming *confirmCommandMsg
+	renderer   *glamour.TermRenderer
+	hint       string
+	overrides  []aiutil.Option
+	budget     int
+	// pinned are the indexes of the messages pinned with /pin, they are saved in the session
+	pinned map[int]bool
+
+	transcript   []*entry
+	streaming    *entry
+	prompt       string
+	interrupted  bool
+	responseChan chan string
+	errChan      chan error
+	cancel       context.CancelFunc
+	busy         bool
+	compacting   bool
+	tokens       int
+	width        int
+	height       int
+	ready        bool
+}
+
+// NewMokiModel creates the chat for a conversation.
+// A conversation with history is resumed, otherwise Moki introduces itself.
+func NewMokiModel(ctx context.Context, client aiutil.Client, conv *aiutil.Conversation, opts Options) MokiModel {
+	input := textarea.New()
+	input.Placeholder = "Message Moki"
+	input.ShowLineNumbers = false
+	input.CharLimit = 0
+	input.MaxHeight = 0
+	input.SetHeight(1)
+	input.FocusedStyle.CursorLine = lipgloss.NewStyle()
+	input.KeyMap.InsertNewline = key.NewBinding(key.WithKeys("alt+enter", "ctrl+j"))
+	input.Focus()
+
+	// The input has the keyboard, so the transcript only scrolls with keys it doesn't use
+	vp := viewport.New(0, 0)
+	vp.KeyMap = viewport.KeyMap{
+		PageDown: key.NewBinding(key.WithKeys("pgdown")),
+		PageUp:   key.NewBinding(key.WithKeys("pgup")),
+		Down:     key.NewBinding(key.WithKeys("ctrl+down")),
+		Up:       key.NewBinding(key.WithKeys("ctrl+up")),
+	}
+
+	m := MokiModel{
+		ctx:        ctx,
+		client:     client,
+		conv:       conv,
+		opts:       opts,
+		viewport:   vp,
+		input:      input,
+		transcript: []*entry{{content: MokiHeader}},
+		tokens:     tokenCount(conv),
+		budget:     opts.MaxTokens,
+	}
+	if m.budget == 0 {
+		m.budget = conv.MaxTokens
+	}
+	m.loadPins()
+	if m.resuming() {
+		m.notice(fmt.Sprintf("Resuming session %s", opts.Session.ID))
+		m.loadHistory()
+	} else {
+		m.busy = true
+	}
+	return m
 }
 
 func (m MokiModel) Init() tea.Cmd {
-	return nil
+	if m.resuming() {
+		return textarea.Blink
+	}
+	return tea.Batch(textarea.Blink, m.introduce())
 }
 
 func (m MokiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.viewport.Width = msg.Width
+		m.input.SetWidth(msg.Width)
+		if m.opts.Markdown {
+			renderer, err := render.NewRenderer(msg.Width)
+			if err == nil {
+				m.renderer = renderer
+			}
+		}
+		m.layout()
+		m.refresh()
+		if !m.ready {
+			m.viewport.GotoBottom()
+			m.ready = true
+		}
+		return m, nil
 	case tea.KeyMsg:
+		if m.confirming != nil {
+			return m.answerConfirm(msg)
+		}
+		if m.picker != nil {
+			picker, cmd := m.picker.Update(msg)
+			m.picker = &picker
+			m.layout()
+			return m, cmd
+		}
+		m.hint = ""
 		switch msg.String() {
-		case "ctrl+c", "esc", "\x1b":
-			m.quit = true
-			return m, tea.Quit
-		case "enter", "\r":
-			return m, tea.Quit
-		case "@":
-			// If we are going to enter a resource, clear the view and reinvoke the text input
-			if !m.selectingResource {
-				m.selectingResource = true
-				m.Blur()
-				return m, tea.Tick(time.Millisecond, func(time.Time) tea.Msg {
-					return tea.KeyMsg{
-						Type:  tea.KeyRunes,
-						Runes: []rune{'@'},
-					}
-				})
+		case "tab":
+			value, matches := completeCommand(m.input.Value())
+			if value != m.input.Value() {
+				m.input.SetValue(value)
 			}
-			// With the input hidden, we can manage the resource selection
-			modifiedInput, err := ManageResourceSelection(m.Value())
-			m.selectingResource = false
-			m.Focus()
-			if err != nil {
-				return m, tea.Quit
-			} else if modifiedInput == m.Value() {
-				// If the user cancels the resource selection, just return
+			if len(matches) > 1 {
+				m.hint = strings.Join(matches, " ")
+			}
+			return m, nil
+		case "ctrl+c", "esc":
+			// While Moki is answering, only the request is cancelled
+			if m.cancel != nil {
+				m.interrupted = true
+				m.cancel()
 				return m, nil
 			}
-			// Update the model with the modified input, including the resource
-			m.SetValue(modifiedInput)
+			return m, tea.Quit
+		case "enter":
+			return m.submit()
+		case "@":
+			picker := newResourcePicker()
+			m.picker = &picker
+			m.input.Blur()
+			m.layout()
 			return m, nil
-		default:
-			// Let the text input handle all other key presses
-			updatedModel, cmd := m.Model.Update(msg)
-			m.Model = updatedModel
+		}
+		if key.Matches(msg, m.viewport.KeyMap.PageDown, m.viewport.KeyMap.PageUp, m.viewport.KeyMap.Down, m.viewport.KeyMap.Up) {
+			var cmd tea.Cmd
+			m.viewport, cmd = m.viewport.Update(msg)
+			return m, cmd
+		}
+		var cmd tea.Cmd
+		m.input, cmd = m.input.Update(msg)
+		m.layout()
+		return m, cmd
+	case tea.MouseMsg:
+		var cmd tea.Cmd
+		m.viewport, cmd = m.viewport.Update(msg)
+		return m, cmd
+	case confirmCommandMsg:
+		m.confirming = &msg
+		m.input.Blur()
+		m.layout()
+		return m, nil
+	case resourceSelectedMsg:
+		m.picker = nil
+		if msg.directive != "" {
+			value := strings.TrimRight(m.input.Value(), " ")
+			if value != "" {
+				value += " "
+			}
+			m.input.SetValue(value + msg.directive)
+		}
+		m.layout()
+		return m, m.input.Focus()
+	case introMsg:
+		m.busy = false
+		if msg.err != nil {
+			m.notice("Failed to get an introduction: " + msg.err.Error())
+		} else {
+			m.transcript = append(m.transcript, &entry{role: "Moki", content: msg.text})
+		}
+		m.refresh()
+		return m, nil
+	case streamStartedMsg:
+		m.prompt = msg.prompt
+		m.responseChan, m.errChan, m.cancel = msg.responseChan, msg.errChan, msg.cancel
+		if len(msg.resources) > 0 {
+			m.notice("Resources added to conversation: " + strings.Join(msg.resources, ","))
+		}
+		for _, warning := range msg.warnings {
+			m.notice("Warning: " + warning)
+		}
+		m.streaming = &entry{role: "Moki"}
+		m.transcript = append(m.transcript, m.streaming)
+		m.refresh()
+		return m, waitForStream(m.responseChan, m.errChan)
+	case streamChunkMsg:
+		if m.streaming == nil {
+			return m, nil
+		}
+		m.streaming.content += string(msg)
+		m.streaming.rendered = ""
+		m.refresh()
+		return m, waitForStream(m.responseChan, m.errChan)
+	case streamDoneMsg:
+		answer := m.streaming.content
+		m.finishStream()
+		if warnings := analyzer.Analyze(answer); len(warnings) > 0 {
+			var b strings.Builder
+			analyzer.PrintWarnings(&b, warnings)
+			m.notice(strings.TrimSpace(b.String()))
+		}
+		m.save()
+		if NeedsCompaction(m.client, m.conv, m.opts.CompactAt) {
+			m.notice("The conversation is nearly full, summarizing the earlier messages...")
+			cmd := m.compact()
+			m.refresh()
 			return m, cmd
 		}
+		m.refresh()
+		return m, nil
+	case compactDoneMsg:
+		if m.cancel != nil {
+			m.cancel()
+		}
+		if msg.compaction != nil {
+			m.pinned = msg.compaction.Pinned
+		}
+		switch {
+		case m.interrupted:
+			m.notice("Compaction cancelled.")
+		case msg.err != nil:
+			m.notice(msg.err.Error())
+		default:
+			m.notice(msg.compaction.String())
+			m.save()
+		}
+		m.cancel, m.busy, m.compacting, m.interrupted = nil, false, false, false
+		m.tokens = tokenCount(m.conv)
+		m.refresh()
+		return m, nil
+	case streamErrMsg:
+		if m.interrupted {
+			m.keepInterrupted()
+			m.finishStream()
+			m.refresh()
+			return m, nil
+		}
+		if m.streaming != nil && m.streaming.content == "" {
+			m.removeStreaming()
+		}
+		m.finishStream()
+		m.notice("Request Failed: " + msg.err.Error())
+		m.refresh()
+		return m, nil
 	}
 	return m, nil
 }
 
 func (m MokiModel) View() string {
-	if m.selectingResource {
-		return ""
+	if !m.ready {
+		return "Loading..."
+	}
+	bottom := m.input.View()
+	if m.picker != nil {
+		bottom = m.picker.View()
+	}
+	if m.confirming != nil {
+		bottom = m.confirmView()
+	}
+	return m.viewport.View() + "\n" + m.statusBar() + "\n" + bottom
+}
+
+// submit sends the input to Moki, unless it is empty or Moki is still answering.
+func (m MokiModel) submit() (tea.Model, tea.Cmd) {
+	input := strings.TrimSpace(m.input.Value())
+	if m.busy || input == "" {
+		return m, nil
+	}
+	if slices.Contains(exitCommands, strings.ToLower(input)) {
+		return m, tea.Quit
+	}
+	if command, args, ok := parseCommand(input); ok {
+		m.input.Reset()
+		m.layout()
+		cmd := m.runCommand(command, input, args)
+		m.refresh()
+		m.viewport.GotoBottom()
+		return m, cmd
+	}
+	m.input.Reset()
+	m.busy = true
+	m.transcript = append(m.transcript, &entry{role: "You", content: input})
+	m.layout()
+	m.refresh()
+	m.viewport.GotoBottom()
+	cmd := m.send(input)
+	return m, cmd
+}
+
+// send adds any resources in the input to the conversation, and starts streaming the response.
+// It can be cancelled while the resources are read, which drops any that were already attached.
+func (m *MokiModel) send(input string) tea.Cmd {
+	ctx, cancel := context.WithCancel(m.ctx)
+	m.cancel = cancel
+	client, conv, opts := m.client, m.conv, m.opts.Resources
+	return func() tea.Msg {
+		history := messages(conv)
+		modifiedInput, resourcesAdded, warnings, err := tools.ManageResources(ctx, conv, input, opts)
+		if ctx.Err() != nil {
+			setMessages(conv, history)
+			return streamErrMsg{err: ctx.Err()}
+		}
+		if err != nil {
+			return streamErrMsg{err: err}
+		}
+		ctxWithTimeout, cancelTimeout := context.WithTimeout(ctx, SingleRequestTime)
+		responseChan, errChan := make(chan string), make(chan error)
+		go client.SendStreamRequest(ctxWithTimeout, conv, modifiedInput, responseChan, errChan)
+		return streamStartedMsg{
+			prompt:       modifiedInput,
+			resources:    resourcesAdded,
+			warnings:     warnings,
+			responseChan: responseChan,
+			errChan:      errChan,
+			cancel: func() {
+				cancelTimeout()
+				cancel()
+			},
+		}
+	}
+}
+
+// compact summarizes the older messages in the background.
+// It can be cancelled like a response.
+func (m *MokiModel) compact() tea.Cmd {
+	ctx, cancel := context.WithTimeout(m.ctx, CompactTime)
+	m.cancel, m.busy, m.compacting = cancel, true, true
+	client, conv, pinned := m.client, m.conv, maps.Clone(m.pinned)
+	return func() tea.Msg {
+		compaction, err := Compact(ctx, client, conv, KeepTurns, pinned)
+		return compactDoneMsg{compaction: compaction, err: err}
 	}
-	m.Focus()
-	return m.Model.View()
+}
+
+// answerConfirm runs the command with y, any other key declines it.
+func (m MokiModel) answerConfirm(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "y", "Y":
+		m.confirming.reply <- true
+	case "n", "N", "esc", "ctrl+c", "enter":
+		m.confirming.reply <- false
+	default:
+		return m, nil
+	}
+	m.confirming = nil
+	m.layout()
+	return m, m.input.Focus()
+}
+
+// confirmView asks to run the command, with any warnings for it.
+func (m MokiModel) confirmView() string {
+	var b strings.Builder
+	if warnings := analyzer.Analyze(m.confirming.command); len(warnings) > 0 {
+		analyzer.PrintWarnings(&b, warnings)
+	}
+	fmt.Fprintf(&b, "Run `%s` and attach its output? (y/n)", m.confirming.command)
+	return lipgloss.NewStyle().Width(m.width).Render(strings.TrimSpace(b.String()))
+}
+
+// runCommand runs a slash command, and shows any error in the transcript.
+func (m *MokiModel) runCommand(command Command, input string, args string) tea.Cmd {
+	if command.Run == nil {
+		m.notice(fmt.Sprintf("Unknown command %s, type /help for a list of commands.", strings.Fields(input)[0]))
+		return nil
+	}
+	cmd, err := command.Run(m, args)
+	if err != nil {
+		m.notice(err.Error())
+	}
+	m.tokens = tokenCount(m.conv)
+	return cmd
+}
+
+// reconnect replaces the client, keeping the changes already made during the conversation.
+func (m *MokiModel) reconnect(opts ...aiutil.Option) error {
+	client, overrides, err := m.connect(opts...)
+	if err != nil {
+		return err
+	}
+	m.client, m.overrides = client, overrides
+	return nil
+}
+
+// connect creates a client with the changes made during the conversation, and the new options.
+func (m *MokiModel) connect(opts ...aiutil.Option) (aiutil.Client, []aiutil.Option, error) {
+	if m.opts.NewClient == nil {
+		return nil, nil, fmt.Errorf("The client can't be changed in this conversation")
+	}
+	overrides := append(slices.Clone(m.overrides), opts...)
+	client, err := m.opts.NewClient(overrides...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return client, overrides, nil
+}
+
+func (m MokiModel) introduce() tea.Cmd {
+	ctx, client := m.ctx, m.client
+	return func() tea.Msg {
+		text, err := GetIntroduction(client, ctx)
+		return introMsg{text: text, err: err}
+	}
+}
+
+// waitForStream waits for the next chunk of the response.
+// The error channel is closed before the response channel, so a closed error
+// channel is ignored until the response is complete.
+func waitForStream(responseChan chan string, errChan chan error) tea.Cmd {
+	return func() tea.Msg {
+		errs := errChan
+		for {
+			select {
+			case chunk, ok := <-responseChan:
+				if !ok {
+					return streamDoneMsg{}
+				}
+				return streamChunkMsg(chunk)
+			case err, ok := <-errs:
+				if !ok {
+					errs = nil
+					continue
+				}
+				if err != nil {
+					return streamErrMsg{err: err}
+				}
+			}
+		}
+	}
+}
+
+func (m *MokiModel) finishStream() {
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.responseChan, m.errChan, m.cancel = nil, nil, nil
+	m.streaming, m.prompt, m.interrupted = nil, "", false
+	m.busy = false
+	m.tokens = tokenCount(m.conv)
+}
+
+// keepInterrupted keeps a cancelled request in the history, with the partial answer marked as interrupted.
+// The provider drops the prompt when its request fails, so it is added back along with the answer.
+func (m *MokiModel) keepInterrupted() {
+	if m.streaming == nil || strings.TrimSpace(m.streaming.content) == "" {
+		m.removeStreaming()
+		m.notice("Response cancelled.")
+		return
+	}
+	answer := strings.TrimRight(m.streaming.content, "\n") + "\n\n" + InterruptedMarker
+	m.streaming.content, m.streaming.rendered = answer, ""
+
+	m.conv.RemoveLastMessageIfRole(openai.ChatMessageRoleUser)
+	if err := m.conv.Append(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: m.prompt}); err != nil {
+		m.notice("Failed to keep the interrupted response: " + err.Error())
+		return
+	}
+	if err := m.conv.Append(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: answer}); err != nil {
+		m.conv.RemoveLastMessageIfRole(openai.ChatMessageRoleUser)
+		m.notice("Failed to keep the interrupted response: " + err.Error())
+		return
+	}
+	m.save()
+}
+
+func (m *MokiModel) removeStreaming() {
+	m.transcript = slices.DeleteFunc(m.transcript, func(e *entry) bool { return e == m.streaming })
+}
+
+// save writes the conversation to the session, if there is one.
+func (m *MokiModel) save() {
+	if m.opts.Session == nil {
+		return
+	}
+	m.opts.Session.Pinned = slices.Sorted(maps.Keys(m.pinned))
+	if err := m.opts.Session.Save(m.conv); err != nil {
+		m.notice("Failed to save session: " + err.Error())
+	}
+}
+
+// loadPins reads the pinned messages from the session, ignoring any past the end of the conversation.
+func (m *MokiModel) loadPins() {
+	m.pinned = map[int]bool{}
+	if m.opts.Session == nil {
+		return
+	}
+	for _, i := range m.opts.Session.Pinned {
+		m.pinned[i] = true
+	}
+	m.unpinFrom(len(messages(m.conv)))
+}
+
+// unpinFrom unpins the messages from index i on, after they were removed from the conversation.
+func (m *MokiModel) unpinFrom(i int) {
+	maps.DeleteFunc(m.pinned, func(pin int, _ bool) bool { return pin >= i })
+}
+
+func (m *MokiModel) notice(text string) {
+	m.transcript = append(m.transcript, &entry{content: text})
+}
+
+// loadHistory adds the user and assistant messages of the conversation to the transcript.
+func (m *MokiModel) loadHistory() {
+	for _, msg := range messages(m.conv) {
+		switch msg.Role {
+		case openai.ChatMessageRoleUser:
+			m.transcript = append(m.transcript, &entry{role: "You", content: msg.Content})
+		case openai.ChatMessageRoleAssistant:
+			m.transcript = append(m.transcript, &entry{role: "Moki", content: msg.Content})
+		case openai.ChatMessageRoleSystem:
+			if isSummary(msg) {
+				m.notice("Earlier messages were summarized to save tokens.")
+			}
+		}
+	}
+}
+
+func (m MokiModel) resuming() bool {
+	return m.opts.Session != nil && len(m.opts.Session.Messages) > 1
+}
+
+// layout sizes the transcript to fill the screen above the status bar and input.
+func (m *MokiModel) layout() {
+	inputHeight := min(max(m.input.LineCount(), 1), maxInputHeight)
+	m.input.SetHeight(inputHeight)
+	if m.picker != nil {
+		inputHeight = lipgloss.Height(m.picker.View())
+	}
+	if m.confirming != nil {
+		inputHeight = lipgloss.Height(m.confirmView())
+	}
+	m.viewport.Height = max(m.height-inputHeight-1, 1)
+}
+
+// refresh redraws the transcript, following the end of it if it was already in view.
+func (m *MokiModel) refresh() {
+	atBottom := m.viewport.AtBottom()
+	views := make([]string, 0, len(m.transcript))
+	for _, e := range m.transcript {
+		views = append(views, m.renderEntry(e))
+	}
+	m.viewport.SetContent(strings.Join(views, "\n\n"))
+	if atBottom {
+		m.viewport.GotoBottom()
+	}
+}
+
+// renderEntry renders a transcript entry for the current width.
+// The result is cached, until the width or content changes.
+func (m *MokiModel) renderEntry(e *entry) string {
+	if e.rendered != "" && e.width == m.width {
+		return e.rendered
+	}
+	wrap := lipgloss.NewStyle().Width(m.width)
+	switch {
+	case e.role == "":
+		e.rendered = wrap.Inherit(noticeStyle).Render(e.content)
+	case e.role == "Moki" && m.renderer != nil:
+		content := e.content
+		if rendered, err := render.Render(m.renderer, content); err == nil {
+			content = rendered
+		}
+		e.rendered = labelStyle.Render(e.role+":") + "\n" + content
+	default:
+		e.rendered = wrap.Render(labelStyle.Render(e.role+":") + " " + e.content)
+	}
+	e.width = m.width
+	return e.rendered
+}
+
+// statusBar shows the model, context and total usage, and session, with the keys on the right.
+func (m MokiModel) statusBar() string {
+	config := m.client.GetConfig()
+	left := fmt.Sprintf(" %s/%s · %d/%d tokens", config.Provider, config.Model, m.tokens, m.conv.MaxTokens)
+	if m.opts.Usage != nil && m.opts.Usage.Total().Tokens() > 0 {
+		left += " · used " + m.opts.Usage.Total().String()
+	}
+	if m.opts.Session != nil {
+		left += " · session " + m.opts.Session.ID
+	}
+	right := "/help · alt+enter newline · @ resource · esc quit "
+	if m.hint != "" {
+		right = m.hint + " "
+	} else if m.interrupted {
+		right = "Cancelling... "
+	} else if m.compacting {
+		right = "Compacting... · esc cancel "
+	} else if m.busy {
+		right = "Thinking... · esc cancel "
+	}
+	gap := m.width - lipgloss.Width(left) - lipgloss.Width(right)
+	if gap < 1 {
+		return statusStyle.Width(m.width).MaxWidth(m.width).Render(left)
+	}
+	return statusStyle.Render(left + strings.Repeat(" ", gap) + right)
+}
+
+func tokenCount(conv *aiutil.Conversation) int {
+	conv.Lock()
+	defer conv.Unlock()
+	return conv.TokenCount
 }
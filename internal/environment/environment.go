@@ -0,0 +1,164 @@
+package environment
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Fields that can be collected and sent to the model.
+const (
+	FieldOS              = "os"
+	FieldDistro          = "distro"
+	FieldShell           = "shell"
+	FieldPackageManagers = "package_managers"
+	FieldCwd             = "cwd"
+	FieldGit             = "git"
+)
+
+// Fields lists every field, in the order they are reported.
+var Fields = []string{FieldOS, FieldDistro, FieldShell, FieldPackageManagers, FieldCwd, FieldGit}
+
+// PackageManagers are detected by looking them up on the PATH.
+var PackageManagers = []string{
+	"apt", "dnf", "yum", "pacman", "zypper", "apk", "emerge", "xbps-install", "nix",
+	"brew", "port", "snap", "flatpak", "pkg", "winget", "choco", "scoop",
+}
+
+// ReferenceID is the id the environment is attached to a conversation with.
+const ReferenceID = "Environment"
+
+// Context describes the user's environment, so answers fit their system.
+type Context struct {
+	OS              string
+	Distro          string
+	Shell           string
+	PackageManagers []string
+	Cwd             string
+	Git             string
+}
+
+// ParseFields splits a comma separated list of fields, and checks they are known.
+// An empty list selects every field.
+func ParseFields(list string) ([]string, error) {
+	if strings.TrimSpace(list) == "" {
+		return Fields, nil
+	}
+	fields := []string{}
+	for _, field := range strings.Split(list, ",") {
+		field = strings.ToLower(strings.TrimSpace(field))
+		if field == "" {
+			continue
+		}
+		if !slices.Contains(Fields, field) {
+			return nil, fmt.Errorf("Unknown environment field: %q (available: %s)", field, strings.Join(Fields, ", "))
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// Collect detects the selected fields of the environment.
+func Collect(fields []string) Context {
+	c := Context{}
+	for _, field := range fields {
+		switch field {
+		case FieldOS:
+			c.OS = runtime.GOOS + "/" + runtime.GOARCH
+		case FieldDistro:
+			c.Distro = detectDistro()
+		case FieldShell:
+			if shell := os.Getenv("SHELL"); shell != "" {
+				c.Shell = filepath.Base(shell)
+			}
+		case FieldPackageManagers:
+			for _, pm := range PackageManagers {
+				if _, err := exec.LookPath(pm); err == nil {
+					c.PackageManagers = append(c.PackageManagers, pm)
+				}
+			}
+		case FieldCwd:
+			c.Cwd, _ = os.Getwd()
+		case FieldGit:
+			c.Git = detectGit()
+		}
+	}
+	return c
+}
+
+// String formats the context as a reference for the model.
+func (c Context) String() string {
+	lines := []string{}
+	add := func(name string, value string) {
+		if value != "" {
+			lines = append(lines, name+": "+value)
+		}
+	}
+	add("OS", c.OS)
+	add("Distribution", c.Distro)
+	add("Shell", c.Shell)
+	add("Package managers", strings.Join(c.PackageManagers, ", "))
+	add("Working directory", c.Cwd)
+	add("Git", c.Git)
+	return strings.Join(lines, "\n")
+}
+
+// detectDistro reads the OS name from /etc/os-release, or sw_vers on macOS.
+func detectDistro() string {
+	if runtime.GOOS == "darwin" {
+		if version := run("sw_vers", "-productVersion"); version != "" {
+			return "macOS " + version
+		}
+		return "macOS"
+	}
+
+	file, err := os.Open("/etc/os-release")
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	values := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if ok {
+			values[key] = strings.Trim(value, `"'`)
+		}
+	}
+	if values["PRETTY_NAME"] != "" {
+		return values["PRETTY_NAME"]
+	}
+	return strings.TrimSpace(values["NAME"] + " " + values["VERSION_ID"])
+}
+
+// detectGit describes the branch and state of the repository in the cwd.
+func detectGit() string {
+	if run("git", "rev-parse", "--is-inside-work-tree") != "true" {
+		return "not a git repository"
+	}
+	branch := run("git", "rev-parse", "--abbrev-ref", "HEAD")
+	status := run("git", "status", "--porcelain")
+	if status == "" {
+		return fmt.Sprintf("branch %s, clean", branch)
+	}
+	return fmt.Sprintf("branch %s, %d uncommitted changes", branch, len(strings.Split(status, "\n")))
+}
+
+// run returns the trimmed output of a command, or nothing if it fails.
+func run(name string, args ...string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, name, args...).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
@@ -0,0 +1,144 @@
+package execute
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/ztkent/moki/internal/analyzer"
+)
+
+// Action is the choice made in the confirm view.
+type Action int
+
+const (
+	Cancel Action = iota
+	Execute
+)
+
+// ConfirmModel shows a command, and lets the user run, edit or cancel it.
+// Commands flagged by the analyzer need an extra confirmation before they run.
+type ConfirmModel struct {
+	textinput.Model
+	command    string
+	warnings   []analyzer.Warning
+	editing    bool
+	confirming bool
+	action     Action
+	done       bool
+}
+
+func NewConfirmModel(command string) ConfirmModel {
+	m := ConfirmModel{Model: textinput.New()}
+	m.setCommand(command)
+	return m
+}
+
+func (m *ConfirmModel) setCommand(command string) {
+	m.command = command
+	m.warnings = analyzer.Analyze(command)
+}
+
+func (m ConfirmModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m ConfirmModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		if m.editing || m.confirming {
+			switch msg.String() {
+			case "ctrl+c":
+				m.done = true
+				return m, tea.Quit
+			case "esc", "\x1b":
+				// Discard the edit, or the extra confirmation
+				m.editing, m.confirming = false, false
+				m.Blur()
+				return m, nil
+			case "enter", "\r":
+				if m.confirming {
+					if strings.ToLower(strings.TrimSpace(m.Value())) != "yes" {
+						m.confirming = false
+						m.Blur()
+						return m, nil
+					}
+					m.action = Execute
+					m.done = true
+					return m, tea.Quit
+				}
+				m.setCommand(strings.TrimSpace(m.Value()))
+				m.editing = false
+				m.Blur()
+				return m, nil
+			default:
+				updatedModel, cmd := m.Model.Update(msg)
+				m.Model = updatedModel
+				return m, cmd
+			}
+		}
+
+		switch msg.String() {
+		case "ctrl+c", "esc", "\x1b", "c", "q", "n":
+			m.done = true
+			return m, tea.Quit
+		case "enter", "\r", "r", "y":
+			if m.command == "" {
+				return m, nil
+			}
+			if len(m.warnings) > 0 {
+				m.confirming = true
+				m.Prompt = "Type 'yes' to run this command: "
+				m.SetValue("")
+				return m, m.Focus()
+			}
+			m.action = Execute
+			m.done = true
+			return m, tea.Quit
+		case "e":
+			m.editing = true
+			m.Prompt = "Edit: "
+			m.SetValue(m.command)
+			m.CursorEnd()
+			return m, m.Focus()
+		}
+	}
+	return m, nil
+}
+
+func (m ConfirmModel) View() string {
+	if m.done {
+		return ""
+	}
+	if m.editing {
+		return m.Model.View() + "\n\n[enter] save  [esc] discard\n"
+	}
+
+	view := fmt.Sprintf("Command:\n  %s\n\n", m.command)
+	for _, warning := range m.warnings {
+		view += "Warning " + warning.String() + "\n"
+	}
+	if len(m.warnings) > 0 {
+		view += "\n"
+	}
+	if m.confirming {
+		return view + m.Model.View() + "\n\n[enter] confirm  [esc] back\n"
+	}
+	return view + "[r]un  [e]dit  [c]ancel\n"
+}
+
+// Confirm asks the user to run, edit or cancel the command.
+// It returns the chosen action, and the command after any edits.
+func Confirm(command string) (Action, string, error) {
+	p := tea.NewProgram(NewConfirmModel(command), tea.WithInputTTY())
+	resModel, err := p.Run()
+	if err != nil {
+		return Cancel, command, err
+	}
+	m, ok := resModel.(ConfirmModel)
+	if !ok {
+		return Cancel, command, fmt.Errorf("Failed to confirm the command")
+	}
+	return m.action, m.command, nil
+}
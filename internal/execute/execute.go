@@ -0,0 +1,52 @@
+package execute
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// Result is the outcome of running a command.
+type Result struct {
+	ExitCode int
+	Duration time.Duration
+}
+
+// Shell returns the user's shell, falling back to /bin/sh.
+func Shell() string {
+	if shell := os.Getenv("SHELL"); shell != "" {
+		return shell
+	}
+	return "/bin/sh"
+}
+
+// Run executes the command in the user's $SHELL.
+// Output goes straight to the terminal as it is produced.
+// A non-zero exit code is not an error, it is reported in the result.
+func Run(ctx context.Context, command string) (Result, error) {
+	result := Result{}
+	if command == "" {
+		return result, fmt.Errorf("Failed to run command: command is empty")
+	}
+
+	cmd := exec.CommandContext(ctx, Shell(), "-c", command)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	start := time.Now()
+	err := cmd.Run()
+	result.Duration = time.Since(start)
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		result.ExitCode = exitErr.ExitCode()
+		return result, nil
+	} else if err != nil {
+		return result, fmt.Errorf("Failed to run command: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,19 @@
+package execute
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	t.Setenv("SHELL", "/bin/sh")
+	for command, want := range map[string]int{"true": 0, "exit 3": 3, "false": 1} {
+		result, err := Run(context.Background(), command)
+		if err != nil || result.ExitCode != want {
+			t.Errorf("Run(%q) = %d, %v, want exit code %d", command, result.ExitCode, err, want)
+		}
+	}
+	if _, err := Run(context.Background(), ""); err == nil {
+		t.Error("Run with an empty command succeeded, want an error")
+	}
+}
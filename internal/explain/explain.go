@@ -0,0 +1,101 @@
+package explain
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ztkent/moki/internal/analyzer"
+	"github.com/ztkent/moki/internal/tools"
+)
+
+// Explanation is a structured breakdown of a shell command.
+type Explanation struct {
+	Command  string   `json:"command"`
+	Summary  string   `json:"summary"`
+	Parts    []Part   `json:"parts"`
+	Warnings []string `json:"warnings"`
+}
+
+// Part is a single token of the command, like a flag, pipe or redirection.
+// Depth is the nesting level inside subshells and command substitutions.
+type Part struct {
+	Token       string `json:"token"`
+	Kind        string `json:"kind"`
+	Depth       int    `json:"depth"`
+	Description string `json:"description"`
+}
+
+// Parse reads the explanation of the command from the model's response.
+// The command is the one the user gave, not the model's copy of it, so the analyzer checks what would actually run.
+// Warnings from the analyzer are added to the ones the model found.
+func Parse(command string, response string) (Explanation, error) {
+	e := Explanation{}
+	raw := tools.StripCodeFences(response)
+	if start, end := strings.Index(raw, "{"), strings.LastIndex(raw, "}"); start >= 0 && end > start {
+		raw = raw[start : end+1]
+	}
+	if err := json.Unmarshal([]byte(raw), &e); err != nil {
+		return e, fmt.Errorf("Failed to parse the explanation: %w", err)
+	}
+	e.Command = command
+	for _, warning := range analyzer.Analyze(command) {
+		e.Warnings = append(e.Warnings, warning.String())
+	}
+	if e.Warnings == nil {
+		e.Warnings = []string{}
+	}
+	return e, nil
+}
+
+// WriteTable writes the explanation as a readable table.
+func WriteTable(w io.Writer, e Explanation) error {
+	fmt.Fprintf(w, "%s\n\n", e.Command)
+	if e.Summary != "" {
+		fmt.Fprintf(w, "%s\n\n", e.Summary)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TOKEN\tKIND\tDESCRIPTION")
+	for _, part := range e.Parts {
+		indent := strings.Repeat("  ", max(part.Depth, 0))
+		fmt.Fprintf(tw, "%s%s\t%s\t%s\n", indent, part.Token, part.Kind, part.Description)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(e.Warnings) > 0 {
+		fmt.Fprintln(w)
+		for _, warning := range e.Warnings {
+			fmt.Fprintln(w, "Warning: "+warning)
+		}
+	}
+	return nil
+}
+
+// WritePlain writes the explanation without aligning it, one tab separated part per line, for output that isn't a terminal.
+func WritePlain(w io.Writer, e Explanation) error {
+	var b strings.Builder
+	fmt.Fprintln(&b, e.Command)
+	if e.Summary != "" {
+		fmt.Fprintln(&b, e.Summary)
+	}
+	for _, part := range e.Parts {
+		fmt.Fprintf(&b, "%s\t%s\t%d\t%s\n", part.Token, part.Kind, max(part.Depth, 0), part.Description)
+	}
+	for _, warning := range e.Warnings {
+		fmt.Fprintln(&b, "Warning: "+warning)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteJSON writes the explanation as indented JSON.
+func WriteJSON(w io.Writer, e Explanation) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(e)
+}
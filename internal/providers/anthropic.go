@@ -0,0 +1,277 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	aiutil "github.com/ztkent/ai-util"
+)
+
+const (
+	AnthropicBaseURL    = "https://api.anthropic.com/v1"
+	AnthropicVersion    = "2023-06-01"
+	AnthropicAPIKeyEnv  = "ANTHROPIC_API_KEY"
+	anthropicProviderID = "Anthropic"
+)
+
+// AnthropicClient talks to the Anthropic Messages API.
+type AnthropicClient struct {
+	config aiutil.ClientConfig
+}
+
+type anthropicMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type anthropicRequest struct {
+	Model       string             `json:"model"`
+	System      string             `json:"system,omitempty"`
+	Messages    []anthropicMessage `json:"messages"`
+	MaxTokens   int                `json:"max_tokens"`
+	Temperature *float64           `json:"temperature,omitempty"`
+	TopP        *float64           `json:"top_p,omitempty"`
+	TopK        *int               `json:"top_k,omitempty"`
+	Stream      bool               `json:"stream,omitempty"`
+}
+
+type anthropicResponse struct {
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	StopReason string `json:"stop_reason"`
+}
+
+type anthropicStreamEvent struct {
+	Type  string `json:"type"`
+	Delta struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"delta"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// anthropicErrorStatus maps the error types sent in a stream to the HTTP status they are returned with otherwise,
+// so an error in the middle of a stream is retried the same way.
+var anthropicErrorStatus = map[string]int{
+	"invalid_request_error": http.StatusBadRequest,
+	"authentication_error":  http.StatusUnauthorized,
+	"permission_error":      http.StatusForbidden,
+	"not_found_error":       http.StatusNotFound,
+	"request_too_large":     http.StatusRequestEntityTooLarge,
+	"rate_limit_error":      http.StatusTooManyRequests,
+	"api_error":             http.StatusInternalServerError,
+	"timeout_error":         http.StatusGatewayTimeout,
+	"overloaded_error":      529,
+}
+
+// ConnectAnthropic establishes a connection with the Anthropic API.
+func ConnectAnthropic(config *aiutil.ClientConfig) (aiutil.Client, error) {
+	if config.Model == "" {
+		config.Model = ClaudeSonnet4.String()
+	} else if model, ok := IsSupportedAnthropicModel(config.Model); !ok {
+		return nil, fmt.Errorf("unsupported Anthropic model specified: %s", config.Model)
+	} else {
+		config.Model = model.String()
+	}
+	if config.BaseURL == "" {
+		config.BaseURL = AnthropicBaseURL
+	}
+	if config.APIKey == "" {
+		config.APIKey = os.Getenv(AnthropicAPIKeyEnv)
+	}
+	if config.APIKey == "" {
+		return nil, fmt.Errorf("API key for provider %s not found (set %s env var)", Anthropic, AnthropicAPIKeyEnv)
+	}
+
+	client := &AnthropicClient{config: *config}
+	return client, aiutil.CheckConnection(client)
+}
+
+// GetConfig returns the client's configuration.
+func (c *AnthropicClient) GetConfig() aiutil.ClientConfig {
+	return c.config
+}
+
+func (c *AnthropicClient) headers() map[string]string {
+	return map[string]string{
+		"x-api-key":         c.config.APIKey,
+		"anthropic-version": AnthropicVersion,
+	}
+}
+
+func (c *AnthropicClient) url(path string) string {
+	return strings.TrimSuffix(c.config.BaseURL, "/") + path
+}
+
+// buildRequest converts the conversation to the Messages API format.
+// System messages, including references, are combined into the system prompt.
+func (c *AnthropicClient) buildRequest(conv *aiutil.Conversation, stream bool) anthropicRequest {
+	system, turns := splitConversation(conv)
+	req := anthropicRequest{
+		Model:       c.config.Model,
+		System:      system,
+		MaxTokens:   AnthropicModel(c.config.Model).MaxOutputTokens(),
+		Temperature: c.config.Temperature,
+		TopP:        c.config.TopP,
+		TopK:        c.config.TopK,
+		Stream:      stream,
+	}
+	if c.config.MaxTokens != nil && *c.config.MaxTokens < req.MaxTokens {
+		req.MaxTokens = *c.config.MaxTokens
+	}
+	for _, turn := range turns {
+		req.Messages = append(req.Messages, anthropicMessage{Role: turn.Role, Content: turn.Content})
+	}
+	return req
+}
+
+// SendCompletionRequest sends a request and waits for the full response.
+func (c *AnthropicClient) SendCompletionRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string) (string, error) {
+	if conv == nil {
+		return "", fmt.Errorf("conversation cannot be nil")
+	}
+	if err := conv.Append(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: userPrompt}); err != nil {
+		return "", fmt.Errorf("failed to append user prompt: %w", err)
+	}
+
+	resp, err := doJSON(ctx, c.config.HTTPClient, anthropicProviderID, http.MethodPost, c.url("/messages"), c.headers(), c.buildRequest(conv, false))
+	if err != nil {
+		conv.RemoveLastMessageIfRole(openai.ChatMessageRoleUser)
+		return "", fmt.Errorf("failed to create message: %w", err)
+	}
+	defer resp.Body.Close()
+
+	message := anthropicResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&message); err != nil {
+		conv.RemoveLastMessageIfRole(openai.ChatMessageRoleUser)
+		return "", fmt.Errorf("failed to decode message: %w", err)
+	}
+	var responseBuilder strings.Builder
+	for _, block := range message.Content {
+		if block.Type == "text" {
+			responseBuilder.WriteString(block.Text)
+		}
+	}
+	responseChat := responseBuilder.String()
+	if responseChat == "" {
+		conv.RemoveLastMessageIfRole(openai.ChatMessageRoleUser)
+		return "", fmt.Errorf("received empty response from model (stop reason: %s)", message.StopReason)
+	}
+
+	if err := conv.Append(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: responseChat}); err != nil {
+		conv.RemoveLastMessageIfRole(openai.ChatMessageRoleUser)
+		return "", fmt.Errorf("failed to append assistant response (token limit likely exceeded): %w", err)
+	}
+	return responseChat, nil
+}
+
+// SendStreamRequest sends a request and streams the response.
+func (c *AnthropicClient) SendStreamRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string, responseChan chan string, errChan chan error) {
+	defer close(responseChan)
+	defer close(errChan)
+
+	if conv == nil {
+		errChan <- fmt.Errorf("conversation cannot be nil")
+		return
+	}
+	if err := conv.Append(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: userPrompt}); err != nil {
+		errChan <- fmt.Errorf("failed to append user prompt: %w", err)
+		return
+	}
+
+	resp, err := doJSON(ctx, c.config.HTTPClient, anthropicProviderID, http.MethodPost, c.url("/messages"), c.headers(), c.buildRequest(conv, true))
+	if err != nil {
+		conv.RemoveLastMessageIfRole(openai.ChatMessageRoleUser)
+		errChan <- fmt.Errorf("failed to create message stream: %w", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	var responseBuilder strings.Builder
+	err = readSSE(resp.Body, func(_ string, data string) error {
+		event := anthropicStreamEvent{}
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return fmt.Errorf("failed to decode stream event: %w", err)
+		}
+		switch event.Type {
+		case "content_block_delta":
+			if event.Delta.Type == "text_delta" && event.Delta.Text != "" {
+				responseBuilder.WriteString(event.Delta.Text)
+				responseChan <- event.Delta.Text
+			}
+		case "error":
+			return &APIError{
+				Provider:   anthropicProviderID,
+				StatusCode: anthropicErrorStatus[event.Error.Type],
+				Message:    event.Error.Type + ": " + event.Error.Message,
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		conv.RemoveLastMessageIfRole(openai.ChatMessageRoleUser)
+		errChan <- fmt.Errorf("error receiving stream data: %w", err)
+		return
+	}
+
+	err = conv.Append(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: responseBuilder.String()})
+	if err != nil {
+		errChan <- fmt.Errorf("failed to append assistant response post-stream (token limit likely exceeded): %w", err)
+		return
+	}
+}
+
+// ListModels lists available Anthropic models.
+func (c *AnthropicClient) ListModels(ctx context.Context) ([]string, error) {
+	resp, err := doJSON(ctx, c.config.HTTPClient, anthropicProviderID, http.MethodGet, c.url("/models?limit=100"), c.headers(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Anthropic models: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to decode Anthropic models: %w", err)
+	}
+	models := make([]string, len(list.Data))
+	for i, model := range list.Data {
+		models[i] = model.ID
+	}
+	return models, nil
+}
+
+// splitConversation separates the system messages from the user and assistant turns.
+// Consecutive turns from the same role are merged, since both Anthropic and Gemini require them to alternate.
+func splitConversation(conv *aiutil.Conversation) (string, []openai.ChatCompletionMessage) {
+	conv.Lock()
+	defer conv.Unlock()
+
+	system := []string{}
+	turns := []openai.ChatCompletionMessage{}
+	for _, m := range conv.Messages {
+		if m.Role == openai.ChatMessageRoleSystem {
+			system = append(system, m.Content)
+			continue
+		}
+		if len(turns) > 0 && turns[len(turns)-1].Role == m.Role {
+			turns[len(turns)-1].Content += "\n\n" + m.Content
+			continue
+		}
+		turns = append(turns, openai.ChatCompletionMessage{Role: m.Role, Content: m.Content})
+	}
+	return strings.TrimSpace(strings.Join(system, "\n\n")), turns
+}
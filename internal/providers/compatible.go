@@ -0,0 +1,193 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	aiutil "github.com/ztkent/ai-util"
+)
+
+const CompatibleAPIKeyEnv = "OPENAI_COMPATIBLE_API_KEY"
+
+// CompatibleClient talks to any server that implements the OpenAI chat API,
+// like Ollama, llama.cpp or vLLM. Unlike the OpenAI client, any model name is accepted.
+type CompatibleClient struct {
+	*openai.Client
+	config aiutil.ClientConfig
+}
+
+// ConnectCompatible connects to the OpenAI-compatible server at the base URL.
+// The API key is optional, without one no Authorization header is sent.
+// If no model is set, the first model listed by the server is used.
+func ConnectCompatible(config *aiutil.ClientConfig) (aiutil.Client, error) {
+	if config.BaseURL == "" {
+		return nil, fmt.Errorf("base URL is required for provider %s (use -base-url, e.g. http://localhost:11434/v1)", OpenAICompatible)
+	}
+	if config.APIKey == "" {
+		config.APIKey = os.Getenv(CompatibleAPIKeyEnv)
+	}
+
+	oaiConfig := openai.DefaultConfig(config.APIKey)
+	oaiConfig.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
+	httpClient := config.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	if config.APIKey == "" {
+		noAuth := *httpClient
+		noAuth.Transport = noAuthTransport{base: httpClient.Transport}
+		httpClient = &noAuth
+	}
+	oaiConfig.HTTPClient = httpClient
+
+	client := &CompatibleClient{
+		Client: openai.NewClientWithConfig(oaiConfig),
+		config: *config,
+	}
+
+	models, err := client.ListModels(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("connection check failed: %w", err)
+	}
+	if client.config.Model == "" {
+		if len(models) == 0 {
+			return nil, fmt.Errorf("no models available at %s (use -m to set one)", config.BaseURL)
+		}
+		client.config.Model = models[0]
+	}
+	return client, nil
+}
+
+// GetConfig returns the client's configuration.
+func (c *CompatibleClient) GetConfig() aiutil.ClientConfig {
+	return c.config
+}
+
+// ListModels lists the models served at /v1/models.
+func (c *CompatibleClient) ListModels(ctx context.Context) ([]string, error) {
+	providerModels, err := c.Client.ListModels(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list models from %s: %w", c.config.BaseURL, err)
+	}
+	models := make([]string, len(providerModels.Models))
+	for i, model := range providerModels.Models {
+		models[i] = model.ID
+	}
+	return models, nil
+}
+
+func (c *CompatibleClient) buildChatCompletionRequest(conv *aiutil.Conversation) openai.ChatCompletionRequest {
+	req := openai.ChatCompletionRequest{
+		Model:    c.config.Model,
+		Messages: conv.Messages,
+	}
+	if c.config.Temperature != nil {
+		req.Temperature = float32(*c.config.Temperature)
+	}
+	if c.config.TopP != nil {
+		req.TopP = float32(*c.config.TopP)
+	}
+	if c.config.MaxTokens != nil {
+		req.MaxTokens = *c.config.MaxTokens
+	}
+	if c.config.Seed != nil {
+		req.Seed = c.config.Seed
+	}
+	return req
+}
+
+// SendCompletionRequest sends a request and waits for the full response.
+func (c *CompatibleClient) SendCompletionRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string) (string, error) {
+	if conv == nil {
+		return "", fmt.Errorf("conversation cannot be nil")
+	}
+	if err := conv.Append(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: userPrompt}); err != nil {
+		return "", fmt.Errorf("failed to append user prompt: %w", err)
+	}
+
+	completion, err := c.CreateChatCompletion(ctx, c.buildChatCompletionRequest(conv))
+	if err != nil {
+		conv.RemoveLastMessageIfRole(openai.ChatMessageRoleUser)
+		return "", fmt.Errorf("failed to create chat completion: %w", err)
+	}
+	if len(completion.Choices) == 0 || completion.Choices[0].Message.Content == "" {
+		conv.RemoveLastMessageIfRole(openai.ChatMessageRoleUser)
+		return "", fmt.Errorf("received empty response from model")
+	}
+
+	responseChat := completion.Choices[0].Message.Content
+	if err := conv.Append(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: responseChat}); err != nil {
+		conv.RemoveLastMessageIfRole(openai.ChatMessageRoleUser)
+		return "", fmt.Errorf("failed to append assistant response (token limit likely exceeded): %w", err)
+	}
+	return responseChat, nil
+}
+
+// SendStreamRequest sends a request and streams the response.
+func (c *CompatibleClient) SendStreamRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string, responseChan chan string, errChan chan error) {
+	defer close(responseChan)
+	defer close(errChan)
+
+	if conv == nil {
+		errChan <- fmt.Errorf("conversation cannot be nil")
+		return
+	}
+	if err := conv.Append(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: userPrompt}); err != nil {
+		errChan <- fmt.Errorf("failed to append user prompt: %w", err)
+		return
+	}
+
+	stream, err := c.CreateChatCompletionStream(ctx, c.buildChatCompletionRequest(conv))
+	if err != nil {
+		conv.RemoveLastMessageIfRole(openai.ChatMessageRoleUser)
+		errChan <- fmt.Errorf("failed to create chat completion stream: %w", err)
+		return
+	}
+	defer stream.Close()
+
+	var responseBuilder strings.Builder
+	for {
+		response, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			conv.RemoveLastMessageIfRole(openai.ChatMessageRoleUser)
+			errChan <- fmt.Errorf("error receiving stream data: %w", err)
+			return
+		}
+		if len(response.Choices) > 0 {
+			delta := response.Choices[0].Delta.Content
+			responseBuilder.WriteString(delta)
+			responseChan <- delta
+		}
+	}
+
+	err = conv.Append(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: responseBuilder.String()})
+	if err != nil {
+		errChan <- fmt.Errorf("failed to append assistant response post-stream (token limit likely exceeded): %w", err)
+		return
+	}
+}
+
+// noAuthTransport drops the empty bearer token go-openai always sets.
+type noAuthTransport struct {
+	base http.RoundTripper
+}
+
+func (t noAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.TrimSpace(req.Header.Get("Authorization")) == "Bearer" {
+		req = req.Clone(req.Context())
+		req.Header.Del("Authorization")
+	}
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
@@ -0,0 +1,282 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	aiutil "github.com/ztkent/ai-util"
+)
+
+const (
+	GeminiBaseURL    = "https://generativelanguage.googleapis.com/v1beta"
+	GeminiAPIKeyEnv  = "GEMINI_API_KEY"
+	geminiProviderID = "Gemini"
+)
+
+// GeminiClient talks to the Google Gemini API.
+type GeminiClient struct {
+	config aiutil.ClientConfig
+}
+
+type geminiPart struct {
+	Text string `json:"text"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiGenerationConfig struct {
+	Temperature     *float64 `json:"temperature,omitempty"`
+	TopP            *float64 `json:"topP,omitempty"`
+	TopK            *int     `json:"topK,omitempty"`
+	Seed            *int     `json:"seed,omitempty"`
+	MaxOutputTokens int      `json:"maxOutputTokens,omitempty"`
+}
+
+type geminiRequest struct {
+	SystemInstruction *geminiContent         `json:"systemInstruction,omitempty"`
+	Contents          []geminiContent        `json:"contents"`
+	GenerationConfig  geminiGenerationConfig `json:"generationConfig"`
+}
+
+type geminiResponse struct {
+	Candidates []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	} `json:"candidates"`
+	PromptFeedback struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback"`
+}
+
+// text joins the parts of the first candidate.
+func (r geminiResponse) text() string {
+	if len(r.Candidates) == 0 {
+		return ""
+	}
+	var text strings.Builder
+	for _, part := range r.Candidates[0].Content.Parts {
+		text.WriteString(part.Text)
+	}
+	return text.String()
+}
+
+// ConnectGemini establishes a connection with the Gemini API.
+// The API key is read from GEMINI_API_KEY, or GOOGLE_API_KEY.
+func ConnectGemini(config *aiutil.ClientConfig) (aiutil.Client, error) {
+	if config.Model == "" {
+		config.Model = Gemini25Flash.String()
+	} else if model, ok := IsSupportedGeminiModel(config.Model); !ok {
+		return nil, fmt.Errorf("unsupported Gemini model specified: %s", config.Model)
+	} else {
+		config.Model = model.String()
+	}
+	if config.BaseURL == "" {
+		config.BaseURL = GeminiBaseURL
+	}
+	if config.APIKey == "" {
+		config.APIKey = os.Getenv(GeminiAPIKeyEnv)
+	}
+	if config.APIKey == "" {
+		config.APIKey = os.Getenv("GOOGLE_API_KEY")
+	}
+	if config.APIKey == "" {
+		return nil, fmt.Errorf("API key for provider %s not found (set %s env var)", Gemini, GeminiAPIKeyEnv)
+	}
+
+	client := &GeminiClient{config: *config}
+	return client, aiutil.CheckConnection(client)
+}
+
+// GetConfig returns the client's configuration.
+func (c *GeminiClient) GetConfig() aiutil.ClientConfig {
+	return c.config
+}
+
+func (c *GeminiClient) headers() map[string]string {
+	return map[string]string{"x-goog-api-key": c.config.APIKey}
+}
+
+func (c *GeminiClient) url(path string) string {
+	return strings.TrimSuffix(c.config.BaseURL, "/") + path
+}
+
+// buildRequest converts the conversation to the generateContent format.
+// System messages, including references, are combined into the system instruction.
+func (c *GeminiClient) buildRequest(conv *aiutil.Conversation) geminiRequest {
+	system, turns := splitConversation(conv)
+	req := geminiRequest{
+		GenerationConfig: geminiGenerationConfig{
+			Temperature:     c.config.Temperature,
+			TopP:            c.config.TopP,
+			TopK:            c.config.TopK,
+			Seed:            c.config.Seed,
+			MaxOutputTokens: GeminiModel(c.config.Model).MaxOutputTokens(),
+		},
+	}
+	if c.config.MaxTokens != nil && *c.config.MaxTokens < req.GenerationConfig.MaxOutputTokens {
+		req.GenerationConfig.MaxOutputTokens = *c.config.MaxTokens
+	}
+	if system != "" {
+		req.SystemInstruction = &geminiContent{Parts: []geminiPart{{Text: system}}}
+	}
+	for _, turn := range turns {
+		role := "user"
+		if turn.Role == openai.ChatMessageRoleAssistant {
+			role = "model"
+		}
+		req.Contents = append(req.Contents, geminiContent{Role: role, Parts: []geminiPart{{Text: turn.Content}}})
+	}
+	return req
+}
+
+// geminiBlockReasons are the finish reasons of a response stopped by a content filter.
+var geminiBlockReasons = map[string]bool{
+	"SAFETY":             true,
+	"RECITATION":         true,
+	"BLOCKLIST":          true,
+	"PROHIBITED_CONTENT": true,
+	"SPII":               true,
+}
+
+// blocked returns an APIError if the prompt or response was blocked by a content filter.
+// Gemini answers a blocked request with a 200, so it is reported as a bad request, which isn't retried.
+func (r geminiResponse) blocked() error {
+	reason := r.PromptFeedback.BlockReason
+	if reason == "" && len(r.Candidates) > 0 && geminiBlockReasons[r.Candidates[0].FinishReason] {
+		reason = r.Candidates[0].FinishReason
+	}
+	if reason == "" {
+		return nil
+	}
+	return &APIError{Provider: geminiProviderID, StatusCode: http.StatusBadRequest, Message: "response blocked by the content filter (" + reason + ")"}
+}
+
+// SendCompletionRequest sends a request and waits for the full response.
+func (c *GeminiClient) SendCompletionRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string) (string, error) {
+	if conv == nil {
+		return "", fmt.Errorf("conversation cannot be nil")
+	}
+	if err := conv.Append(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: userPrompt}); err != nil {
+		return "", fmt.Errorf("failed to append user prompt: %w", err)
+	}
+
+	path := "/models/" + c.config.Model + ":generateContent"
+	resp, err := doJSON(ctx, c.config.HTTPClient, geminiProviderID, http.MethodPost, c.url(path), c.headers(), c.buildRequest(conv))
+	if err != nil {
+		conv.RemoveLastMessageIfRole(openai.ChatMessageRoleUser)
+		return "", fmt.Errorf("failed to generate content: %w", err)
+	}
+	defer resp.Body.Close()
+
+	content := geminiResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&content); err != nil {
+		conv.RemoveLastMessageIfRole(openai.ChatMessageRoleUser)
+		return "", fmt.Errorf("failed to decode content: %w", err)
+	}
+	if err := content.blocked(); err != nil {
+		conv.RemoveLastMessageIfRole(openai.ChatMessageRoleUser)
+		return "", err
+	}
+	responseChat := content.text()
+	if responseChat == "" {
+		reason := content.PromptFeedback.BlockReason
+		if len(content.Candidates) > 0 {
+			reason = content.Candidates[0].FinishReason
+		}
+		conv.RemoveLastMessageIfRole(openai.ChatMessageRoleUser)
+		return "", fmt.Errorf("received empty response from model (finish reason: %s)", reason)
+	}
+
+	if err := conv.Append(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: responseChat}); err != nil {
+		conv.RemoveLastMessageIfRole(openai.ChatMessageRoleUser)
+		return "", fmt.Errorf("failed to append assistant response (token limit likely exceeded): %w", err)
+	}
+	return responseChat, nil
+}
+
+// SendStreamRequest sends a request and streams the response.
+func (c *GeminiClient) SendStreamRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string, responseChan chan string, errChan chan error) {
+	defer close(responseChan)
+	defer close(errChan)
+
+	if conv == nil {
+		errChan <- fmt.Errorf("conversation cannot be nil")
+		return
+	}
+	if err := conv.Append(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: userPrompt}); err != nil {
+		errChan <- fmt.Errorf("failed to append user prompt: %w", err)
+		return
+	}
+
+	path := "/models/" + c.config.Model + ":streamGenerateContent?alt=sse"
+	resp, err := doJSON(ctx, c.config.HTTPClient, geminiProviderID, http.MethodPost, c.url(path), c.headers(), c.buildRequest(conv))
+	if err != nil {
+		conv.RemoveLastMessageIfRole(openai.ChatMessageRoleUser)
+		errChan <- fmt.Errorf("failed to create content stream: %w", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	var responseBuilder strings.Builder
+	err = readSSE(resp.Body, func(_ string, data string) error {
+		content := geminiResponse{}
+		if err := json.Unmarshal([]byte(data), &content); err != nil {
+			return fmt.Errorf("failed to decode stream data: %w", err)
+		}
+		if err := content.blocked(); err != nil {
+			return err
+		}
+		if delta := content.text(); delta != "" {
+			responseBuilder.WriteString(delta)
+			responseChan <- delta
+		}
+		return nil
+	})
+	if err != nil {
+		conv.RemoveLastMessageIfRole(openai.ChatMessageRoleUser)
+		errChan <- fmt.Errorf("error receiving stream data: %w", err)
+		return
+	}
+	if responseBuilder.Len() == 0 {
+		conv.RemoveLastMessageIfRole(openai.ChatMessageRoleUser)
+		errChan <- fmt.Errorf("received empty response from model")
+		return
+	}
+
+	err = conv.Append(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: responseBuilder.String()})
+	if err != nil {
+		errChan <- fmt.Errorf("failed to append assistant response post-stream (token limit likely exceeded): %w", err)
+		return
+	}
+}
+
+// ListModels lists available Gemini models.
+func (c *GeminiClient) ListModels(ctx context.Context) ([]string, error) {
+	resp, err := doJSON(ctx, c.config.HTTPClient, geminiProviderID, http.MethodGet, c.url("/models?pageSize=1000"), c.headers(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Gemini models: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var list struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to decode Gemini models: %w", err)
+	}
+	models := make([]string, len(list.Models))
+	for i, model := range list.Models {
+		models[i] = strings.TrimPrefix(model.Name, "models/")
+	}
+	return models, nil
+}
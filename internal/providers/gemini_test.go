@@ -0,0 +1,115 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	aiutil "github.com/ztkent/ai-util"
+)
+
+// fakeGemini serves the Gemini API, answering every generate request with the response.
+// Streamed requests get one event per response.
+func fakeGemini(t *testing.T, responses ...string) aiutil.Client {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, ":streamGenerateContent"):
+			w.Header().Set("Content-Type", "text/event-stream")
+			for _, response := range responses {
+				fmt.Fprintf(w, "data: %s\n\n", response)
+			}
+		case strings.HasSuffix(r.URL.Path, ":generateContent"):
+			fmt.Fprint(w, responses[len(responses)-1])
+		default:
+			fmt.Fprint(w, `{"models":[]}`)
+		}
+	}))
+	t.Cleanup(server.Close)
+	client, err := ConnectGemini(&aiutil.ClientConfig{
+		Provider:   string(Gemini),
+		Model:      string(Gemini25Flash),
+		APIKey:     "test",
+		BaseURL:    server.URL,
+		HTTPClient: server.Client(),
+	})
+	if err != nil {
+		t.Fatalf("ConnectGemini: %v", err)
+	}
+	return client
+}
+
+const (
+	geminiText          = `{"candidates":[{"content":{"parts":[{"text":"hello"}]}}]}`
+	geminiPromptBlocked = `{"promptFeedback":{"blockReason":"SAFETY"}}`
+	geminiSafetyStop    = `{"candidates":[{"content":{"parts":[]},"finishReason":"SAFETY"}]}`
+)
+
+// streamTo streams a response into the conversation.
+func streamTo(client aiutil.Client, conv *aiutil.Conversation) (string, error) {
+	chunks, errs := make(chan string), make(chan error, 1)
+	go client.SendStreamRequest(context.Background(), conv, "prompt", chunks, errs)
+	var response strings.Builder
+	for chunk := range chunks {
+		response.WriteString(chunk)
+	}
+	return response.String(), <-errs
+}
+
+func TestGeminiBlocked(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses []string
+		reason    string
+	}{
+		{"prompt blocked", []string{geminiPromptBlocked}, "SAFETY"},
+		{"response stopped", []string{geminiText, geminiSafetyStop}, "SAFETY"},
+		{"recitation", []string{`{"candidates":[{"finishReason":"RECITATION"}]}`}, "RECITATION"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fakeGemini(t, tt.responses...)
+			for _, name := range []string{"completion", "stream"} {
+				conv := aiutil.NewConversation("", 10000, false)
+				var err error
+				if name == "completion" {
+					_, err = client.SendCompletionRequest(context.Background(), conv, "prompt")
+				} else {
+					_, err = streamTo(client, conv)
+				}
+				var apiErr *APIError
+				if !errors.As(err, &apiErr) || !strings.Contains(apiErr.Message, tt.reason) {
+					t.Errorf("%s: error = %v, want an APIError with the block reason %s", name, err, tt.reason)
+				}
+				if Retryable(err) {
+					t.Errorf("%s: Retryable(%v) = true, a blocked request fails again", name, err)
+				}
+				if len(conv.Messages) != 1 {
+					t.Errorf("%s: messages = %v, want the prompt removed", name, conv.Messages)
+				}
+			}
+		})
+	}
+}
+
+func TestGeminiStream(t *testing.T) {
+	client := fakeGemini(t, geminiText, `{"candidates":[{"content":{"parts":[{"text":" world"}]},"finishReason":"STOP"}]}`)
+	conv := aiutil.NewConversation("", 10000, false)
+	response, err := streamTo(client, conv)
+	if err != nil || response != "hello world" {
+		t.Fatalf("response = %q, %v, want the streamed text", response, err)
+	}
+	if len(conv.Messages) != 3 || conv.Messages[2].Content != "hello world" {
+		t.Errorf("messages = %v, want the prompt and answer", conv.Messages)
+	}
+
+	// A stream without text isn't added as an empty answer
+	conv = aiutil.NewConversation("", 10000, false)
+	if _, err := streamTo(fakeGemini(t, `{"candidates":[{"finishReason":"STOP"}]}`), conv); err == nil || len(conv.Messages) != 1 {
+		t.Errorf("error = %v, messages = %v, want an empty response error and no answer", err, conv.Messages)
+	}
+}
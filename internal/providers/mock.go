@@ -0,0 +1,289 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	aiutil "github.com/ztkent/ai-util"
+)
+
+const (
+	MockFixtureEnv   = "MOKI_MOCK_FIXTURE"
+	DefaultMockModel = "mock"
+	mockProviderID   = "Mock"
+)
+
+// MockFixture is a script of responses for the mock provider.
+type MockFixture struct {
+	Models    []string       `json:"models"`
+	Responses []MockResponse `json:"responses"`
+}
+
+// MockResponse is a single scripted response.
+// Match is a regex checked against the user prompt, an empty match accepts any prompt.
+// If Chunks is empty, Text is streamed one word at a time.
+// Error is returned after ErrorAfter chunks have been streamed.
+// With a Status, the error is an APIError with that status and RetryAfter, so it is retried like a provider's.
+type MockResponse struct {
+	Match      string   `json:"match,omitempty"`
+	Text       string   `json:"text,omitempty"`
+	Chunks     []string `json:"chunks,omitempty"`
+	Delay      string   `json:"delay,omitempty"`
+	Error      string   `json:"error,omitempty"`
+	ErrorAfter int      `json:"error_after,omitempty"`
+	Status     int      `json:"status,omitempty"`
+	RetryAfter string   `json:"retry_after,omitempty"`
+	match      *regexp.Regexp
+	delay      time.Duration
+}
+
+// MockClient replays scripted responses, so moki can run without a network.
+type MockClient struct {
+	config  aiutil.ClientConfig
+	fixture MockFixture
+	used    []bool
+	mu      sync.Mutex
+}
+
+// ConnectMock creates a mock client from the fixture in $MOKI_MOCK_FIXTURE.
+// Without a fixture, the mock echoes the user prompt.
+func ConnectMock(config *aiutil.ClientConfig) (aiutil.Client, error) {
+	fixture := MockFixture{}
+	if path := os.Getenv(MockFixtureEnv); path != "" {
+		loaded, err := LoadMockFixture(path)
+		if err != nil {
+			return nil, err
+		}
+		fixture = loaded
+	}
+	if config.Model == "" {
+		config.Model = DefaultMockModel
+		if len(fixture.Models) > 0 {
+			config.Model = fixture.Models[0]
+		}
+	}
+	return NewMockClient(*config, fixture)
+}
+
+// NewMockClient creates a mock client that replays the fixture.
+func NewMockClient(config aiutil.ClientConfig, fixture MockFixture) (*MockClient, error) {
+	fixture.Responses = slices.Clone(fixture.Responses)
+	if err := fixture.compile(); err != nil {
+		return nil, err
+	}
+	return &MockClient{
+		config:  config,
+		fixture: fixture,
+		used:    make([]bool, len(fixture.Responses)),
+	}, nil
+}
+
+// MustMockClient creates a mock client for the model that replays the responses, for tests.
+// Like regexp.MustCompile, it panics if a response is invalid.
+func MustMockClient(model string, responses ...MockResponse) *MockClient {
+	client, err := NewMockClient(aiutil.ClientConfig{Provider: string(Mock), Model: model}, MockFixture{Responses: responses})
+	if err != nil {
+		panic(err)
+	}
+	return client
+}
+
+// LoadMockFixture reads and validates a JSON fixture file.
+func LoadMockFixture(path string) (MockFixture, error) {
+	fixture := MockFixture{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fixture, fmt.Errorf("Failed to read mock fixture %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return fixture, fmt.Errorf("Failed to parse mock fixture %s: %w", path, err)
+	}
+	if err := fixture.compile(); err != nil {
+		return fixture, fmt.Errorf("Invalid mock fixture %s: %w", path, err)
+	}
+	return fixture, nil
+}
+
+// compile parses the match and delay of each response.
+func (f *MockFixture) compile() error {
+	var err error
+	for i := range f.Responses {
+		r := &f.Responses[i]
+		if r.Match != "" {
+			if r.match, err = regexp.Compile(r.Match); err != nil {
+				return fmt.Errorf("Invalid match in mock response %d: %w", i, err)
+			}
+		}
+		if r.Delay != "" {
+			if r.delay, err = time.ParseDuration(r.Delay); err != nil {
+				return fmt.Errorf("Invalid delay in mock response %d: %w", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// GetConfig returns the client's configuration.
+func (c *MockClient) GetConfig() aiutil.ClientConfig {
+	return c.config
+}
+
+// ListModels lists the fixture models.
+func (c *MockClient) ListModels(ctx context.Context) ([]string, error) {
+	if len(c.fixture.Models) == 0 {
+		return []string{DefaultMockModel}, nil
+	}
+	return c.fixture.Models, nil
+}
+
+// SendCompletionRequest replays the next matching response in full.
+func (c *MockClient) SendCompletionRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string) (string, error) {
+	if conv == nil {
+		return "", fmt.Errorf("conversation cannot be nil")
+	}
+	if err := conv.Append(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: userPrompt}); err != nil {
+		return "", fmt.Errorf("failed to append user prompt: %w", err)
+	}
+
+	response := c.next(userPrompt)
+	chunks := response.chunks()
+	failure := response.err()
+	if failure != nil && response.ErrorAfter <= 0 {
+		conv.RemoveLastMessageIfRole(openai.ChatMessageRoleUser)
+		return "", failure
+	}
+	select {
+	case <-ctx.Done():
+		conv.RemoveLastMessageIfRole(openai.ChatMessageRoleUser)
+		return "", ctx.Err()
+	case <-time.After(response.delay * time.Duration(len(chunks))):
+	}
+	if failure != nil {
+		conv.RemoveLastMessageIfRole(openai.ChatMessageRoleUser)
+		return "", failure
+	}
+
+	responseChat := strings.Join(chunks, "")
+	if err := conv.Append(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: responseChat}); err != nil {
+		conv.RemoveLastMessageIfRole(openai.ChatMessageRoleUser)
+		return "", fmt.Errorf("failed to append assistant response (token limit likely exceeded): %w", err)
+	}
+	return responseChat, nil
+}
+
+// SendStreamRequest replays the next matching response, one chunk at a time.
+func (c *MockClient) SendStreamRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string, responseChan chan string, errChan chan error) {
+	defer close(responseChan)
+	defer close(errChan)
+
+	if conv == nil {
+		errChan <- fmt.Errorf("conversation cannot be nil")
+		return
+	}
+	if err := conv.Append(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: userPrompt}); err != nil {
+		errChan <- fmt.Errorf("failed to append user prompt: %w", err)
+		return
+	}
+
+	response := c.next(userPrompt)
+	failure := response.err()
+	cancelled := func() {
+		conv.RemoveLastMessageIfRole(openai.ChatMessageRoleUser)
+		errChan <- fmt.Errorf("error receiving stream data: %w", ctx.Err())
+	}
+	var responseBuilder strings.Builder
+	for i, chunk := range response.chunks() {
+		if failure != nil && i == response.ErrorAfter {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			cancelled()
+			return
+		case <-time.After(response.delay):
+		}
+		responseBuilder.WriteString(chunk)
+		// The reader may have stopped, so don't wait on it once the request is cancelled
+		select {
+		case responseChan <- chunk:
+		case <-ctx.Done():
+			cancelled()
+			return
+		}
+	}
+	if failure != nil {
+		conv.RemoveLastMessageIfRole(openai.ChatMessageRoleUser)
+		errChan <- fmt.Errorf("error receiving stream data: %w", failure)
+		return
+	}
+
+	err := conv.Append(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: responseBuilder.String()})
+	if err != nil {
+		errChan <- fmt.Errorf("failed to append assistant response post-stream (token limit likely exceeded): %w", err)
+		return
+	}
+}
+
+// next picks the first unused response that matches the prompt.
+// Once every matching response is used, the script starts over.
+func (c *MockClient) next(userPrompt string) MockResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.fixture.Responses) == 0 {
+		return MockResponse{Text: userPrompt}
+	}
+	for pass := 0; pass < 2; pass++ {
+		for i, r := range c.fixture.Responses {
+			if c.used[i] || (r.match != nil && !r.match.MatchString(userPrompt)) {
+				continue
+			}
+			c.used[i] = true
+			return r
+		}
+		// Reset the responses this prompt could have used
+		for i, r := range c.fixture.Responses {
+			if r.match == nil || r.match.MatchString(userPrompt) {
+				c.used[i] = false
+			}
+		}
+	}
+	return MockResponse{Error: fmt.Sprintf("no mock response matches prompt: %q", userPrompt)}
+}
+
+// err is the error the response fails with, or nil if it succeeds.
+func (r MockResponse) err() error {
+	switch {
+	case r.Status != 0:
+		message := r.Error
+		if message == "" {
+			message = http.StatusText(r.Status)
+		}
+		return &APIError{Provider: mockProviderID, StatusCode: r.Status, Message: message, RetryAfter: r.RetryAfter}
+	case r.Error != "":
+		return fmt.Errorf("mock error: %s", r.Error)
+	}
+	return nil
+}
+
+func (r MockResponse) chunks() []string {
+	if len(r.Chunks) > 0 {
+		return r.Chunks
+	}
+	chunks := []string{}
+	for _, word := range strings.SplitAfter(r.Text, " ") {
+		if word != "" {
+			chunks = append(chunks, word)
+		}
+	}
+	return chunks
+}
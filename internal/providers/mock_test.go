@@ -0,0 +1,175 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	aiutil "github.com/ztkent/ai-util"
+)
+
+func TestMockEcho(t *testing.T) {
+	conv := aiutil.NewConversation("", 10000, false)
+	response, err := MustMockClient(DefaultMockModel).SendCompletionRequest(context.Background(), conv, "echo this")
+	if err != nil || response != "echo this" {
+		t.Fatalf("response = %q, %v, want the prompt back", response, err)
+	}
+	// The conversation starts with the system prompt
+	if len(conv.Messages) != 3 || conv.Messages[2].Content != "echo this" {
+		t.Errorf("messages = %v, want the prompt and response", conv.Messages)
+	}
+}
+
+func TestMockMatch(t *testing.T) {
+	client := MustMockClient(DefaultMockModel,
+		MockResponse{Match: "files", Text: "ls -la"},
+		MockResponse{Match: "files", Text: "find ."},
+		MockResponse{Text: "anything"},
+	)
+	tests := []struct {
+		prompt string
+		want   string
+	}{
+		{"list files", "ls -la"},
+		{"hello", "anything"},
+		{"list files again", "find ."},
+		// Every matching response was used, so the script starts over
+		{"more files", "ls -la"},
+	}
+	for _, tt := range tests {
+		response, err := client.SendCompletionRequest(context.Background(), aiutil.NewConversation("", 10000, false), tt.prompt)
+		if err != nil || response != tt.want {
+			t.Errorf("response to %q = %q, %v, want %q", tt.prompt, response, err, tt.want)
+		}
+	}
+}
+
+func TestMockNoMatch(t *testing.T) {
+	client := MustMockClient(DefaultMockModel, MockResponse{Match: "^only$", Text: "ok"})
+	conv := aiutil.NewConversation("", 10000, false)
+	if _, err := client.SendCompletionRequest(context.Background(), conv, "other"); err == nil || !strings.Contains(err.Error(), "no mock response matches") {
+		t.Errorf("error = %v, want no match", err)
+	}
+	if len(conv.Messages) != 1 {
+		t.Errorf("messages = %v, want the prompt removed after the error", conv.Messages)
+	}
+}
+
+func TestMockStream(t *testing.T) {
+	tests := []struct {
+		name     string
+		response MockResponse
+		want     string
+		status   int
+		err      bool
+	}{
+		{name: "words", response: MockResponse{Text: "one two three"}, want: "one two three"},
+		{name: "chunks", response: MockResponse{Chunks: []string{"ls", " -la"}}, want: "ls -la"},
+		{name: "error", response: MockResponse{Text: "one two", Error: "broken"}, err: true},
+		{name: "error after a chunk", response: MockResponse{Text: "one two", Error: "broken", ErrorAfter: 1}, want: "one ", err: true},
+		{name: "status", response: MockResponse{Text: "one two", Status: 529}, status: 529, err: true},
+		{name: "status after a chunk", response: MockResponse{Text: "one two", Status: 429, ErrorAfter: 1}, want: "one ", status: 429, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conv := aiutil.NewConversation("", 10000, false)
+			chunks, errs := make(chan string), make(chan error, 1)
+			go MustMockClient(DefaultMockModel, tt.response).SendStreamRequest(context.Background(), conv, "prompt", chunks, errs)
+			var response strings.Builder
+			for chunk := range chunks {
+				response.WriteString(chunk)
+			}
+			err := <-errs
+			if tt.err != (err != nil) {
+				t.Fatalf("error = %v, want an error: %v", err, tt.err)
+			}
+			if response.String() != tt.want {
+				t.Errorf("response = %q, want %q", response.String(), tt.want)
+			}
+			if status := StatusCode(err); status != tt.status {
+				t.Errorf("StatusCode(%v) = %d, want %d", err, status, tt.status)
+			}
+			if wantMessages := map[bool]int{true: 1, false: 3}[tt.err]; len(conv.Messages) != wantMessages {
+				t.Errorf("conversation has %d messages, want %d", len(conv.Messages), wantMessages)
+			}
+		})
+	}
+}
+
+func TestMockStatusError(t *testing.T) {
+	client := MustMockClient(DefaultMockModel, MockResponse{Status: 429, RetryAfter: "5"})
+	_, err := client.SendCompletionRequest(context.Background(), aiutil.NewConversation("", 10000, false), "prompt")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 429 || apiErr.Message != "Too Many Requests" {
+		t.Fatalf("error = %#v, want a 429 APIError", err)
+	}
+	if !Retryable(err) {
+		t.Errorf("Retryable(%v) = false, want true", err)
+	}
+	if wait, ok := RetryAfter(err); !ok || wait != 5*time.Second {
+		t.Errorf("RetryAfter(%v) = %s, %v, want 5s", err, wait, ok)
+	}
+}
+
+func TestMockCancel(t *testing.T) {
+	client := MustMockClient(DefaultMockModel, MockResponse{Text: "slow answer", Delay: "1m"})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	conv := aiutil.NewConversation("", 10000, false)
+	if _, err := client.SendCompletionRequest(ctx, conv, "prompt"); !errors.Is(err, context.Canceled) {
+		t.Errorf("error = %v, want it cancelled", err)
+	}
+
+	// A stream whose reader stopped returns once it is cancelled, instead of waiting on the reader
+	client = MustMockClient(DefaultMockModel, MockResponse{Chunks: []string{"one", "two"}})
+	ctx, cancel = context.WithCancel(context.Background())
+	chunks, errs := make(chan string), make(chan error, 1)
+	done := make(chan struct{})
+	go func() {
+		client.SendStreamRequest(ctx, conv, "prompt", chunks, errs)
+		close(done)
+	}()
+	// Read one chunk, then stop while the mock is sending the next
+	<-chunks
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SendStreamRequest is still waiting on a reader that stopped")
+	}
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("error = %v, want it cancelled", err)
+	}
+	if len(conv.Messages) != 1 {
+		t.Errorf("messages = %v, want the prompt removed", conv.Messages)
+	}
+}
+
+func TestLoadMockFixture(t *testing.T) {
+	fixture, err := LoadMockFixture(filepath.Join("..", "..", "testdata", "mock", "oneshot.json"))
+	if err != nil {
+		t.Fatalf("LoadMockFixture: %v", err)
+	}
+	if len(fixture.Models) == 0 || len(fixture.Responses) == 0 {
+		t.Errorf("fixture = %+v, want models and responses", fixture)
+	}
+
+	for name, data := range map[string]string{
+		"invalid json":  `{"responses": [`,
+		"invalid match": `{"responses": [{"match": "("}]}`,
+		"invalid delay": `{"responses": [{"delay": "soon"}]}`,
+	} {
+		path := filepath.Join(t.TempDir(), "fixture.json")
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadMockFixture(path); err == nil {
+			t.Errorf("LoadMockFixture with %s succeeded, want an error", name)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package providers
+
+import (
+	"strings"
+
+	aiutil "github.com/ztkent/ai-util"
+)
+
+type AnthropicModel string
+type GeminiModel string
+
+const (
+	// Anthropic Models
+	Claude35Haiku  AnthropicModel = "claude-3-5-haiku-latest"
+	Claude37Sonnet AnthropicModel = "claude-3-7-sonnet-latest"
+	ClaudeSonnet4  AnthropicModel = "claude-sonnet-4-0"
+	ClaudeOpus4    AnthropicModel = "claude-opus-4-0"
+	// Google Gemini Models
+	Gemini20Flash     GeminiModel = "gemini-2.0-flash"
+	Gemini20FlashLite GeminiModel = "gemini-2.0-flash-lite"
+	Gemini25Flash     GeminiModel = "gemini-2.5-flash"
+	Gemini25Pro       GeminiModel = "gemini-2.5-pro"
+)
+
+func (a AnthropicModel) String() string {
+	return string(a)
+}
+
+func (g GeminiModel) String() string {
+	return string(g)
+}
+
+// MaxOutputTokens is the most tokens the model can generate in one response.
+func (a AnthropicModel) MaxOutputTokens() int {
+	switch a {
+	case Claude35Haiku:
+		return 8192
+	case Claude37Sonnet, ClaudeSonnet4:
+		return 64000
+	case ClaudeOpus4:
+		return 32000
+	default:
+		return 8192
+	}
+}
+
+// MaxOutputTokens is the most tokens the model can generate in one response.
+func (g GeminiModel) MaxOutputTokens() int {
+	switch g {
+	case Gemini25Flash, Gemini25Pro:
+		return 65536
+	default:
+		return 8192
+	}
+}
+
+// ContextWindow is the most tokens the model can read in one request, including the response.
+func (a AnthropicModel) ContextWindow() int {
+	return 200000
+}
+
+// ContextWindow is the most tokens the model can read in one request, including the response.
+func (g GeminiModel) ContextWindow() int {
+	return 1048576
+}
+
+// openAIContextWindows and replicateContextWindows are the context windows of the models aiutil supports.
+var openAIContextWindows = map[aiutil.OpenAIModel]int{
+	aiutil.GPT35Turbo: 16385,
+	aiutil.GPT4:       8192,
+	aiutil.GPT4Turbo:  128000,
+	aiutil.GPT4O:      128000,
+	aiutil.GPT4OMini:  128000,
+	aiutil.O1Preview:  128000,
+	aiutil.O1Mini:     128000,
+	aiutil.GPT41:      1047576,
+}
+
+var replicateContextWindows = map[aiutil.ReplicateModel]int{
+	aiutil.MetaLlama38b:          8192,
+	aiutil.MetaLlama370b:         8192,
+	aiutil.MetaLlama38bInstruct:  8192,
+	aiutil.MetaLlama370bInstruct: 8192,
+	aiutil.Mistral7B:             8192,
+	aiutil.Mistral7BInstruct:     32768,
+	aiutil.Mixtral8x7BInstruct:   32768,
+}
+
+// ContextWindow returns the context window of a model, if it is known.
+// Aliases are resolved, so it can be called with the model from the client config.
+func ContextWindow(provider string, model string) (int, bool) {
+	switch aiutil.Provider(provider) {
+	case Anthropic:
+		if m, ok := IsSupportedAnthropicModel(model); ok {
+			return m.ContextWindow(), true
+		}
+	case Gemini:
+		if m, ok := IsSupportedGeminiModel(model); ok {
+			return m.ContextWindow(), true
+		}
+	case aiutil.OpenAI:
+		if m, ok := aiutil.IsSupportedOpenAIModel(model); ok {
+			return openAIContextWindows[m], true
+		}
+	case aiutil.Replicate:
+		// Replicate models may be pinned to a version, e.g. owner/name:version
+		name, _, _ := strings.Cut(model, ":")
+		if m, ok := aiutil.IsSupportedReplicateModel(name); ok {
+			return replicateContextWindows[m], true
+		}
+	}
+	return 0, false
+}
+
+// MaxCompletionTokens returns the most tokens a response from the client can use.
+// It is the max tokens of the config, capped by the model's max output where that is known.
+func MaxCompletionTokens(config aiutil.ClientConfig) int {
+	maxTokens := 0
+	if config.MaxTokens != nil {
+		maxTokens = *config.MaxTokens
+	}
+	modelMax := 0
+	switch aiutil.Provider(config.Provider) {
+	case Anthropic:
+		if m, ok := IsSupportedAnthropicModel(config.Model); ok {
+			modelMax = m.MaxOutputTokens()
+		}
+	case Gemini:
+		if m, ok := IsSupportedGeminiModel(config.Model); ok {
+			modelMax = m.MaxOutputTokens()
+		}
+	}
+	if modelMax > 0 && (maxTokens == 0 || modelMax < maxTokens) {
+		return modelMax
+	}
+	return maxTokens
+}
+
+func IsSupportedAnthropicModel(name string) (AnthropicModel, bool) {
+	switch strings.ToLower(name) {
+	case Claude35Haiku.String(), "haiku":
+		return Claude35Haiku, true
+	case Claude37Sonnet.String(), "sonnet37":
+		return Claude37Sonnet, true
+	case ClaudeSonnet4.String(), "sonnet":
+		return ClaudeSonnet4, true
+	case ClaudeOpus4.String(), "opus":
+		return ClaudeOpus4, true
+	default:
+		// Accept any dated model id, e.g. claude-3-5-haiku-20241022
+		if strings.HasPrefix(strings.ToLower(name), "claude-") {
+			return AnthropicModel(strings.ToLower(name)), true
+		}
+		return "", false
+	}
+}
+
+func IsSupportedGeminiModel(name string) (GeminiModel, bool) {
+	switch strings.ToLower(name) {
+	case Gemini20Flash.String(), "flash2":
+		return Gemini20Flash, true
+	case Gemini20FlashLite.String(), "flash-lite":
+		return Gemini20FlashLite, true
+	case Gemini25Flash.String(), "flash":
+		return Gemini25Flash, true
+	case Gemini25Pro.String(), "pro":
+		return Gemini25Pro, true
+	default:
+		// Accept any other model id, e.g. gemini-1.5-pro-002
+		if strings.HasPrefix(strings.ToLower(name), "gemini-") {
+			return GeminiModel(strings.ToLower(name)), true
+		}
+		return "", false
+	}
+}
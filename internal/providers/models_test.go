@@ -0,0 +1,26 @@
+package providers
+
+import (
+	"testing"
+
+	aiutil "github.com/ztkent/ai-util"
+)
+
+func TestMaxCompletionTokens(t *testing.T) {
+	maxTokens := func(n int) *int { return &n }
+	tests := []struct {
+		config aiutil.ClientConfig
+		want   int
+	}{
+		{aiutil.ClientConfig{Provider: string(Anthropic), Model: string(Claude35Haiku), MaxTokens: maxTokens(32768)}, 8192},
+		{aiutil.ClientConfig{Provider: string(Anthropic), Model: string(ClaudeSonnet4), MaxTokens: maxTokens(1000)}, 1000},
+		{aiutil.ClientConfig{Provider: string(Gemini), Model: string(Gemini20Flash)}, 8192},
+		{aiutil.ClientConfig{Provider: string(aiutil.OpenAI), Model: string(aiutil.GPT4O), MaxTokens: maxTokens(2000)}, 2000},
+		{aiutil.ClientConfig{Provider: string(aiutil.OpenAI), Model: string(aiutil.GPT4O)}, 0},
+	}
+	for _, tt := range tests {
+		if got := MaxCompletionTokens(tt.config); got != tt.want {
+			t.Errorf("MaxCompletionTokens(%s/%s) = %d, want %d", tt.config.Provider, tt.config.Model, got, tt.want)
+		}
+	}
+}
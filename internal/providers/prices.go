@@ -0,0 +1,76 @@
+package providers
+
+import (
+	"strings"
+
+	aiutil "github.com/ztkent/ai-util"
+)
+
+// Price is the cost of a model in US dollars, per million tokens.
+type Price struct {
+	Input  float64
+	Output float64
+}
+
+// Cost is the price of a request with the given prompt and completion tokens.
+func (p Price) Cost(promptTokens int, completionTokens int) float64 {
+	return (float64(promptTokens)*p.Input + float64(completionTokens)*p.Output) / 1_000_000
+}
+
+// prices are the published list prices of the models moki knows.
+// Local and OpenAI compatible models are free, or priced by the server, so they aren't listed.
+var prices = map[string]Price{
+	// OpenAI
+	string(aiutil.GPT35Turbo): {Input: 0.50, Output: 1.50},
+	string(aiutil.GPT4):       {Input: 30, Output: 60},
+	string(aiutil.GPT4Turbo):  {Input: 10, Output: 30},
+	string(aiutil.GPT4O):      {Input: 2.50, Output: 10},
+	string(aiutil.GPT4OMini):  {Input: 0.15, Output: 0.60},
+	string(aiutil.O1Preview):  {Input: 15, Output: 60},
+	string(aiutil.O1Mini):     {Input: 3, Output: 12},
+	string(aiutil.GPT41):      {Input: 2, Output: 8},
+	// Replicate
+	string(aiutil.MetaLlama38b):          {Input: 0.05, Output: 0.25},
+	string(aiutil.MetaLlama38bInstruct):  {Input: 0.05, Output: 0.25},
+	string(aiutil.MetaLlama370b):         {Input: 0.65, Output: 2.75},
+	string(aiutil.MetaLlama370bInstruct): {Input: 0.65, Output: 2.75},
+	string(aiutil.Mistral7B):             {Input: 0.05, Output: 0.25},
+	string(aiutil.Mistral7BInstruct):     {Input: 0.05, Output: 0.25},
+	string(aiutil.Mixtral8x7BInstruct):   {Input: 0.30, Output: 1.00},
+	// Anthropic
+	Claude35Haiku.String():  {Input: 0.80, Output: 4},
+	Claude37Sonnet.String(): {Input: 3, Output: 15},
+	ClaudeSonnet4.String():  {Input: 3, Output: 15},
+	ClaudeOpus4.String():    {Input: 15, Output: 75},
+	// Gemini
+	Gemini20Flash.String():     {Input: 0.10, Output: 0.40},
+	Gemini20FlashLite.String(): {Input: 0.075, Output: 0.30},
+	Gemini25Flash.String():     {Input: 0.30, Output: 2.50},
+	Gemini25Pro.String():       {Input: 1.25, Output: 10},
+}
+
+// PriceOf returns the price of a model, if it is known.
+// Aliases are resolved, so it can be called with the model from the client config.
+func PriceOf(provider string, model string) (Price, bool) {
+	switch aiutil.Provider(provider) {
+	case Anthropic:
+		if m, ok := IsSupportedAnthropicModel(model); ok {
+			model = m.String()
+		}
+	case Gemini:
+		if m, ok := IsSupportedGeminiModel(model); ok {
+			model = m.String()
+		}
+	case aiutil.OpenAI:
+		if m, ok := aiutil.IsSupportedOpenAIModel(model); ok {
+			model = string(m)
+		}
+	case aiutil.Replicate:
+		// Replicate models may be pinned to a version, e.g. owner/name:version
+		model, _, _ = strings.Cut(model, ":")
+	default:
+		return Price{}, false
+	}
+	price, ok := prices[model]
+	return price, ok
+}
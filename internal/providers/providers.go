@@ -0,0 +1,42 @@
+package providers
+
+import (
+	"net/http"
+
+	aiutil "github.com/ztkent/ai-util"
+)
+
+// Providers moki implements itself, alongside the ones aiutil ships.
+const (
+	Anthropic        aiutil.Provider = "anthropic"
+	Gemini           aiutil.Provider = "gemini"
+	Mock             aiutil.Provider = "mock"
+	OpenAICompatible aiutil.Provider = "openai-compatible"
+)
+
+// Providers are every provider moki can connect to.
+var Providers = []aiutil.Provider{aiutil.OpenAI, aiutil.Replicate, Anthropic, Gemini, OpenAICompatible, Mock}
+
+// NewAIClient creates a client for the configured provider.
+// Providers implemented by moki are handled here, the rest are passed to aiutil.
+func NewAIClient(opts ...aiutil.Option) (aiutil.Client, error) {
+	config := aiutil.ClientConfig{
+		HTTPClient: http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	switch aiutil.Provider(config.Provider) {
+	case Anthropic:
+		return ConnectAnthropic(&config)
+	case Gemini:
+		return ConnectGemini(&config)
+	case Mock:
+		return ConnectMock(&config)
+	case OpenAICompatible:
+		return ConnectCompatible(&config)
+	default:
+		return aiutil.NewAIClient(opts...)
+	}
+}
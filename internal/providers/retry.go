@@ -0,0 +1,323 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/replicate/replicate-go"
+	"github.com/sashabaranov/go-openai"
+	aiutil "github.com/ztkent/ai-util"
+)
+
+const (
+	// DefaultRetries is the number of times a failed request is retried, before trying a fallback.
+	DefaultRetries = 3
+	// DefaultBackoff is the wait before the first retry, it doubles after each one.
+	DefaultBackoff = time.Second
+	// DefaultMaxBackoff caps the wait between retries. A longer Retry-After moves on to the fallback instead.
+	DefaultMaxBackoff = time.Second * 30
+)
+
+// RetryPolicy sets how failed requests are retried, and which clients to fall back to.
+type RetryPolicy struct {
+	// Retries is the number of times a request is retried on each client
+	Retries int
+	// Backoff is the wait before the first retry, it doubles after each one, with jitter
+	Backoff time.Duration
+	// MaxBackoff caps the wait between retries
+	MaxBackoff time.Duration
+	// Sleep waits between retries, it returns early if the context is done
+	Sleep func(ctx context.Context, d time.Duration) error
+	// OnRetry is called before each retry and fallback, if set
+	OnRetry func(r Retry)
+}
+
+// DefaultRetryPolicy retries a request 3 times, waiting 1s, 2s and 4s with jitter.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		Retries:    DefaultRetries,
+		Backoff:    DefaultBackoff,
+		MaxBackoff: DefaultMaxBackoff,
+		Sleep:      sleep,
+	}
+}
+
+// Retry describes a failed request that is about to be retried, or sent to a fallback.
+type Retry struct {
+	// Provider and Model are the client the request is sent to next
+	Provider string
+	Model    string
+	// Attempt counts the retries on this client, it is 0 for a fallback
+	Attempt int
+	Wait    time.Duration
+	Err     error
+	// Skipped is set when the fallback couldn't connect, Err is the reason
+	Skipped bool
+}
+
+func (r Retry) String() string {
+	name := r.Provider
+	if r.Model != "" {
+		name += "/" + r.Model
+	}
+	switch {
+	case r.Skipped:
+		return fmt.Sprintf("Skipping the fallback %s, it failed to connect: %v", name, r.Err)
+	case r.Attempt == 0:
+		return fmt.Sprintf("Request failed, falling back to %s: %v", name, r.Err)
+	}
+	return fmt.Sprintf("Request failed, retry %d on %s in %s: %v", r.Attempt, name, r.Wait.Round(time.Millisecond), r.Err)
+}
+
+// Fallback is a client to send the request to once the others failed.
+// It is only connected the first time it is needed, so an unavailable fallback doesn't stop the others.
+type Fallback struct {
+	Provider string
+	Model    string
+	Connect  func() (aiutil.Client, error)
+}
+
+// ParseFallback splits a fallback into its provider and model, e.g. "anthropic/sonnet".
+// Without a model, the provider's default model is used.
+func ParseFallback(fallback string) (string, string, error) {
+	provider, model, _ := strings.Cut(strings.TrimSpace(fallback), "/")
+	provider = strings.ToLower(provider)
+	if !slices.Contains(Providers, aiutil.Provider(provider)) {
+		return "", "", fmt.Errorf("Invalid fallback %q, use provider/model with a provider of: %s", fallback, providerNames())
+	}
+	return provider, model, nil
+}
+
+func providerNames() string {
+	names := make([]string, 0, len(Providers))
+	for _, p := range Providers {
+		names = append(names, string(p))
+	}
+	return strings.Join(names, ", ")
+}
+
+type retryClient struct {
+	aiutil.Client
+	fallbacks []fallbackClient
+	policy    RetryPolicy
+
+	// answered is the client that sent the last response, its config is reported by GetConfig
+	mu       sync.Mutex
+	answered aiutil.Client
+}
+
+type fallbackClient struct {
+	Fallback
+	connect func() (aiutil.Client, error)
+}
+
+// WithRetries wraps the client, so requests that fail with a retryable error are retried with backoff.
+// Once the retries are used up, each fallback is tried in order. Other errors, like an invalid request,
+// a bad API key or a blocked prompt, would fail the same way elsewhere, so they are returned unchanged.
+// A streamed request is only retried if none of the response was sent.
+func WithRetries(client aiutil.Client, policy RetryPolicy, fallbacks ...Fallback) aiutil.Client {
+	if policy.Sleep == nil {
+		policy.Sleep = sleep
+	}
+	c := &retryClient{Client: client, policy: policy, answered: client}
+	for _, fallback := range fallbacks {
+		// A fallback that failed to connect isn't tried again
+		c.fallbacks = append(c.fallbacks, fallbackClient{Fallback: fallback, connect: sync.OnceValues(fallback.Connect)})
+	}
+	return c
+}
+
+// GetConfig returns the config of the client that sent the last response, so it names the fallback that answered.
+// Before the first response, it is the config of the wrapped client.
+func (c *retryClient) GetConfig() aiutil.ClientConfig {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.answered.GetConfig()
+}
+
+func (c *retryClient) SendCompletionRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string) (string, error) {
+	var response string
+	err := c.try(ctx, func(client aiutil.Client) (bool, error) {
+		var err error
+		response, err = client.SendCompletionRequest(ctx, conv, userPrompt)
+		return false, err
+	})
+	return response, err
+}
+
+func (c *retryClient) SendStreamRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string, responseChan chan string, errChan chan error) {
+	defer close(responseChan)
+	defer close(errChan)
+	err := c.try(ctx, func(client aiutil.Client) (bool, error) {
+		chunks, errs := make(chan string), make(chan error)
+		go client.SendStreamRequest(ctx, conv, userPrompt, chunks, errs)
+		streamed := false
+		var streamErr error
+		for chunks != nil || errs != nil {
+			select {
+			case chunk, ok := <-chunks:
+				if !ok {
+					chunks = nil
+					continue
+				}
+				streamed = true
+				// The reader may have stopped after cancelling, so don't wait on it once the request is over
+				select {
+				case responseChan <- chunk:
+				case <-ctx.Done():
+				}
+			case err, ok := <-errs:
+				if !ok {
+					errs = nil
+					continue
+				}
+				if streamErr == nil {
+					streamErr = err
+				}
+			}
+		}
+		return streamed, streamErr
+	})
+	if err != nil {
+		errChan <- err
+	}
+}
+
+// try sends the request to each client in turn, retrying it with backoff.
+// The request returns whether some of the response was already sent, so it can't be retried.
+func (c *retryClient) try(ctx context.Context, request func(client aiutil.Client) (bool, error)) error {
+	var err error
+	for i := 0; i <= len(c.fallbacks); i++ {
+		client := c.Client
+		if i > 0 {
+			if !Retryable(err) {
+				return err
+			}
+			fallback := c.fallbacks[i-1]
+			var connectErr error
+			if client, connectErr = fallback.connect(); connectErr != nil {
+				if c.policy.OnRetry != nil {
+					c.policy.OnRetry(Retry{Provider: fallback.Provider, Model: fallback.Model, Err: connectErr, Skipped: true})
+				}
+				continue
+			}
+			c.notify(client, 0, 0, err)
+		}
+		for attempt := 1; ; attempt++ {
+			var sent bool
+			sent, err = request(client)
+			if err == nil || sent {
+				c.mu.Lock()
+				c.answered = client
+				c.mu.Unlock()
+			}
+			if err == nil || sent || ctx.Err() != nil {
+				return err
+			}
+			wait, ok := c.backoff(attempt, err)
+			if !ok {
+				break
+			}
+			c.notify(client, attempt, wait, err)
+			if c.policy.Sleep(ctx, wait) != nil {
+				return err
+			}
+		}
+	}
+	return err
+}
+
+// backoff returns how long to wait before the retry, or false if the request shouldn't be retried.
+// The provider's Retry-After is used if it has one, otherwise the wait doubles with each attempt.
+func (c *retryClient) backoff(attempt int, err error) (time.Duration, bool) {
+	if attempt > c.policy.Retries || !Retryable(err) {
+		return 0, false
+	}
+	if wait, ok := RetryAfter(err); ok {
+		return wait, wait <= c.policy.MaxBackoff
+	}
+	wait := min(c.policy.Backoff<<(attempt-1), c.policy.MaxBackoff)
+	// Jitter spreads out the retries of clients that failed together
+	return wait/2 + rand.N(wait/2+1), true
+}
+
+func (c *retryClient) notify(client aiutil.Client, attempt int, wait time.Duration, err error) {
+	if c.policy.OnRetry == nil {
+		return
+	}
+	config := client.GetConfig()
+	c.policy.OnRetry(Retry{Provider: config.Provider, Model: config.Model, Attempt: attempt, Wait: wait, Err: err})
+}
+
+// StatusCode returns the HTTP status of a failed request, or 0 if the error didn't come from a provider.
+func StatusCode(err error) int {
+	var apiErr *APIError
+	var openaiErr *openai.APIError
+	var requestErr *openai.RequestError
+	var replicateErr *replicate.APIError
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr.StatusCode
+	case errors.As(err, &openaiErr):
+		return openaiErr.HTTPStatusCode
+	case errors.As(err, &requestErr):
+		return requestErr.HTTPStatusCode
+	case errors.As(err, &replicateErr):
+		return replicateErr.Status
+	}
+	return 0
+}
+
+// Retryable reports whether a request that failed with err may succeed if it is sent again.
+// Rate limits, server errors and network timeouts are retryable.
+func Retryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	switch status := StatusCode(err); {
+	case status == http.StatusRequestTimeout, status == http.StatusTooManyRequests:
+		return true
+	case status >= 500:
+		return status != http.StatusNotImplemented
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// RetryAfter returns the wait the provider asked for before the next request, in seconds or as a date.
+// Only an APIError, from the Anthropic and Gemini clients, has one. The go-openai and Replicate clients
+// drop the response headers from their errors, so the OpenAI, OpenAI-compatible and Replicate requests always use the backoff.
+func RetryAfter(err error) (time.Duration, bool) {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.RetryAfter == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(apiErr.RetryAfter); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(apiErr.RetryAfter); err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
+
+// sleep waits for d, or until the context is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
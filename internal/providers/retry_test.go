@@ -0,0 +1,349 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	aiutil "github.com/ztkent/ai-util"
+)
+
+// fakeAnthropic serves the Anthropic API. Each message request is answered by the next handler,
+// and the last handler answers the rest. It returns a client connected to it, and the number of message requests.
+func fakeAnthropic(t *testing.T, model string, handlers ...http.HandlerFunc) (aiutil.Client, *atomic.Int32) {
+	t.Helper()
+	requests := &atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/models") {
+			fmt.Fprint(w, `{"data":[]}`)
+			return
+		}
+		n := int(requests.Add(1))
+		handlers[min(n, len(handlers))-1](w, r)
+	}))
+	t.Cleanup(server.Close)
+	client, err := ConnectAnthropic(&aiutil.ClientConfig{
+		Provider:   string(Anthropic),
+		Model:      model,
+		APIKey:     "test",
+		BaseURL:    server.URL,
+		HTTPClient: server.Client(),
+	})
+	if err != nil {
+		t.Fatalf("ConnectAnthropic: %v", err)
+	}
+	return client, requests
+}
+
+func status(code int, retryAfter string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		w.WriteHeader(code)
+		fmt.Fprintf(w, `{"type":"error","error":{"type":"error","message":"status %d"}}`, code)
+	}
+}
+
+func answer(text string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(readBody(r), `"stream":true`) {
+			stream(sseDelta(text), sseStop())(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"content":[{"type":"text","text":%q}],"stop_reason":"end_turn"}`, text)
+	}
+}
+
+func stream(events ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range events {
+			fmt.Fprint(w, event)
+		}
+	}
+}
+
+func sseDelta(text string) string {
+	return fmt.Sprintf("event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":%q}}\n\n", text)
+}
+
+func sseStop() string {
+	return "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"
+}
+
+func sseError(errorType string) string {
+	return fmt.Sprintf("event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":%q,\"message\":\"failed\"}}\n\n", errorType)
+}
+
+func readBody(r *http.Request) string {
+	var body strings.Builder
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Body.Read(buf)
+		body.Write(buf[:n])
+		if err != nil {
+			return body.String()
+		}
+	}
+}
+
+// testPolicy records the waits instead of sleeping.
+func testPolicy(retries int, waits *[]time.Duration) RetryPolicy {
+	policy := DefaultRetryPolicy()
+	policy.Retries = retries
+	policy.Sleep = func(ctx context.Context, d time.Duration) error {
+		*waits = append(*waits, d)
+		return nil
+	}
+	return policy
+}
+
+func complete(client aiutil.Client) (string, error) {
+	conv := aiutil.NewConversation("", 10000, false)
+	return client.SendCompletionRequest(context.Background(), conv, "hello")
+}
+
+func completeStream(client aiutil.Client) (string, error) {
+	conv := aiutil.NewConversation("", 10000, false)
+	chunks, errs := make(chan string), make(chan error, 1)
+	go client.SendStreamRequest(context.Background(), conv, "hello", chunks, errs)
+	var response strings.Builder
+	for chunk := range chunks {
+		response.WriteString(chunk)
+	}
+	return response.String(), <-errs
+}
+
+func TestRetryStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		handlers []http.HandlerFunc
+		requests int32
+		err      bool
+	}{
+		{"rate limit", []http.HandlerFunc{status(429, ""), answer("ok")}, 2, false},
+		{"server error", []http.HandlerFunc{status(500, ""), status(503, ""), answer("ok")}, 3, false},
+		{"overloaded", []http.HandlerFunc{status(529, ""), answer("ok")}, 2, false},
+		{"bad request", []http.HandlerFunc{status(400, ""), answer("ok")}, 1, true},
+		{"unauthorized", []http.HandlerFunc{status(401, ""), answer("ok")}, 1, true},
+		{"not implemented", []http.HandlerFunc{status(501, ""), answer("ok")}, 1, true},
+		{"retries used up", []http.HandlerFunc{status(503, "")}, 3, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, requests := fakeAnthropic(t, "", tt.handlers...)
+			waits := []time.Duration{}
+			response, err := complete(WithRetries(client, testPolicy(2, &waits)))
+			if tt.err != (err != nil) {
+				t.Fatalf("error = %v, want an error: %v", err, tt.err)
+			}
+			if !tt.err && response != "ok" {
+				t.Errorf("response = %q, want %q", response, "ok")
+			}
+			if requests.Load() != tt.requests {
+				t.Errorf("sent %d requests, want %d", requests.Load(), tt.requests)
+			}
+			if len(waits) != int(tt.requests)-1 {
+				t.Errorf("waited %d times, want %d", len(waits), tt.requests-1)
+			}
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	client, _ := fakeAnthropic(t, "", status(503, ""))
+	waits := []time.Duration{}
+	if _, err := complete(WithRetries(client, testPolicy(3, &waits))); err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(waits) != 3 {
+		t.Fatalf("waited %d times, want 3", len(waits))
+	}
+	// Each wait is between half and all of 1s, 2s and 4s
+	for i, wait := range waits {
+		full := DefaultBackoff << i
+		if wait < full/2 || wait > full {
+			t.Errorf("wait %d = %s, want between %s and %s", i+1, wait, full/2, full)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	date := time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat)
+	tests := []struct {
+		name       string
+		retryAfter string
+		min, max   time.Duration
+	}{
+		{"seconds", "7", 7 * time.Second, 7 * time.Second},
+		{"date", date, 8 * time.Second, 10 * time.Second},
+		{"invalid", "soon", DefaultBackoff / 2, DefaultBackoff},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, requests := fakeAnthropic(t, "", status(429, tt.retryAfter), answer("ok"))
+			waits := []time.Duration{}
+			if _, err := complete(WithRetries(client, testPolicy(3, &waits))); err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			if requests.Load() != 2 || len(waits) != 1 {
+				t.Fatalf("sent %d requests and waited %d times, want 2 and 1", requests.Load(), len(waits))
+			}
+			if waits[0] < tt.min || waits[0] > tt.max {
+				t.Errorf("wait = %s, want between %s and %s", waits[0], tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestRetryAfterOverMaxBackoff(t *testing.T) {
+	client, requests := fakeAnthropic(t, "", status(429, "3600"), answer("ok"))
+	fallback, _ := fakeAnthropic(t, "claude-3-5-haiku-latest", answer("fallback"))
+	waits := []time.Duration{}
+	response, err := complete(WithRetries(client, testPolicy(3, &waits), Fallback{Connect: func() (aiutil.Client, error) { return fallback, nil }}))
+	if err != nil {
+		t.Fatalf("error = %v", err)
+	}
+	if response != "fallback" || requests.Load() != 1 || len(waits) != 0 {
+		t.Errorf("response = %q after %d requests and %d waits, want the fallback without retrying", response, requests.Load(), len(waits))
+	}
+}
+
+func TestFallback(t *testing.T) {
+	client, requests := fakeAnthropic(t, "", status(503, ""))
+	fallback, fallbackRequests := fakeAnthropic(t, "claude-3-5-haiku-latest", answer("fallback"))
+	waits := []time.Duration{}
+	retries := []Retry{}
+	policy := testPolicy(2, &waits)
+	policy.OnRetry = func(r Retry) { retries = append(retries, r) }
+
+	retrying := WithRetries(client, policy, Fallback{Connect: func() (aiutil.Client, error) { return fallback, nil }})
+	if model := retrying.GetConfig().Model; model != ClaudeSonnet4.String() {
+		t.Errorf("GetConfig().Model = %q before a request, want the client's %q", model, ClaudeSonnet4)
+	}
+	response, err := complete(retrying)
+	if err != nil {
+		t.Fatalf("error = %v", err)
+	}
+	if response != "fallback" {
+		t.Errorf("response = %q, want %q", response, "fallback")
+	}
+	if requests.Load() != 3 || fallbackRequests.Load() != 1 {
+		t.Errorf("sent %d requests and %d to the fallback, want 3 and 1", requests.Load(), fallbackRequests.Load())
+	}
+	if len(retries) != 3 || retries[2].Attempt != 0 || retries[2].Model != "claude-3-5-haiku-latest" {
+		t.Errorf("retries = %v, want 2 retries then the fallback", retries)
+	}
+	if model := retrying.GetConfig().Model; model != "claude-3-5-haiku-latest" {
+		t.Errorf("GetConfig().Model = %q, want the fallback that answered", model)
+	}
+}
+
+func TestFallbackSkipped(t *testing.T) {
+	client, _ := fakeAnthropic(t, "", status(503, ""))
+	fallback, _ := fakeAnthropic(t, "claude-3-5-haiku-latest", answer("fallback"))
+	connects := 0
+	unavailable := Fallback{Provider: "gemini", Connect: func() (aiutil.Client, error) {
+		connects++
+		return nil, errors.New("no API key")
+	}}
+	skipped := []Retry{}
+	waits := []time.Duration{}
+	policy := testPolicy(0, &waits)
+	policy.OnRetry = func(r Retry) {
+		if r.Skipped {
+			skipped = append(skipped, r)
+		}
+	}
+
+	retrying := WithRetries(client, policy, unavailable, Fallback{Connect: func() (aiutil.Client, error) { return fallback, nil }})
+	for range 2 {
+		if response, err := complete(retrying); err != nil || response != "fallback" {
+			t.Fatalf("response = %q, %v, want the second fallback", response, err)
+		}
+	}
+	if connects != 1 {
+		t.Errorf("connected %d times, want only the first time it was needed", connects)
+	}
+	if len(skipped) != 2 || skipped[0].Provider != "gemini" {
+		t.Errorf("skipped = %v, want the unavailable fallback each time", skipped)
+	}
+}
+
+func TestNoFallback(t *testing.T) {
+	// These errors would fail the same way on every provider, so they are returned unchanged
+	tests := []struct {
+		name     string
+		response MockResponse
+		status   int
+	}{
+		{"local error", MockResponse{Error: "spending limit"}, 0},
+		{"invalid request", MockResponse{Status: 400, Error: "invalid request"}, 400},
+		{"bad API key", MockResponse{Status: 401, Error: "invalid x-api-key"}, 401},
+		{"not implemented", MockResponse{Status: 501}, 501},
+		{"blocked prompt", MockResponse{Status: 400, Error: "response blocked by the content filter (SAFETY)"}, 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := MustMockClient(DefaultMockModel, tt.response)
+			fallbackUsed := false
+			waits := []time.Duration{}
+			_, err := complete(WithRetries(client, testPolicy(3, &waits), Fallback{Connect: func() (aiutil.Client, error) {
+				fallbackUsed = true
+				return client, nil
+			}}))
+			if err == nil || fallbackUsed || len(waits) != 0 {
+				t.Errorf("error = %v after %d waits, fallback used: %v, want the error without retrying", err, len(waits), fallbackUsed)
+			}
+			if status := StatusCode(err); status != tt.status {
+				t.Errorf("StatusCode(%v) = %d, want the error unchanged with %d", err, status, tt.status)
+			}
+		})
+	}
+}
+
+func TestStreamRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		handlers []http.HandlerFunc
+		response string
+		requests int32
+		err      bool
+	}{
+		{"before the stream", []http.HandlerFunc{status(429, ""), answer("ok")}, "ok", 2, false},
+		{"error event before any text", []http.HandlerFunc{stream(sseError("overloaded_error")), answer("ok")}, "ok", 2, false},
+		{"rejected error event", []http.HandlerFunc{stream(sseError("invalid_request_error")), answer("ok")}, "", 1, true},
+		{"after part of the response", []http.HandlerFunc{stream(sseDelta("part"), sseError("overloaded_error")), answer("ok")}, "part", 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, requests := fakeAnthropic(t, "", tt.handlers...)
+			waits := []time.Duration{}
+			response, err := completeStream(WithRetries(client, testPolicy(3, &waits)))
+			if tt.err != (err != nil) {
+				t.Fatalf("error = %v, want an error: %v", err, tt.err)
+			}
+			if response != tt.response {
+				t.Errorf("response = %q, want %q", response, tt.response)
+			}
+			if requests.Load() != tt.requests {
+				t.Errorf("sent %d requests, want %d", requests.Load(), tt.requests)
+			}
+		})
+	}
+}
+
+func TestStreamErrorStatus(t *testing.T) {
+	client, _ := fakeAnthropic(t, "", stream(sseError("rate_limit_error")))
+	_, err := completeStream(client)
+	if status := StatusCode(err); status != http.StatusTooManyRequests {
+		t.Errorf("StatusCode(%v) = %d, want %d", err, status, http.StatusTooManyRequests)
+	}
+}
@@ -0,0 +1,107 @@
+package render
+
+import (
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/glamour"
+	"github.com/charmbracelet/glamour/styles"
+	"github.com/charmbracelet/x/term"
+	"github.com/muesli/termenv"
+)
+
+const DefaultWidth = 80
+
+// Enabled reports whether markdown should be rendered to stdout.
+// Rendering is off with -no-color, when NO_COLOR is set, or when stdout is not a terminal.
+func Enabled(noColor bool) bool {
+	if noColor || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	return term.IsTerminal(os.Stdout.Fd())
+}
+
+// Width returns the width of the terminal, or DefaultWidth if it is unknown.
+func Width() int {
+	width, _, err := term.GetSize(os.Stdout.Fd())
+	if err != nil || width <= 0 {
+		return DefaultWidth
+	}
+	return width
+}
+
+// style resolves GLAMOUR_STYLE once, detecting the terminal background if it is unset.
+// Detecting the background queries the terminal, which can't be done once a
+// bubbletea program is reading its input.
+var style = sync.OnceValue(func() string {
+	if style := os.Getenv("GLAMOUR_STYLE"); style != "" && style != styles.AutoStyle {
+		return style
+	}
+	if !term.IsTerminal(os.Stdout.Fd()) {
+		return styles.NoTTYStyle
+	}
+	if termenv.HasDarkBackground() {
+		return styles.DarkStyle
+	}
+	return styles.LightStyle
+})
+
+// NewRenderer creates a markdown renderer that wraps at the width.
+// The style is taken from GLAMOUR_STYLE, or detected from the terminal background.
+func NewRenderer(width int) (*glamour.TermRenderer, error) {
+	return glamour.NewTermRenderer(
+		glamour.WithStylePath(style()),
+		glamour.WithWordWrap(width),
+	)
+}
+
+// Markdown renders a complete markdown document.
+func Markdown(text string, width int) (string, error) {
+	renderer, err := NewRenderer(width)
+	if err != nil {
+		return text, err
+	}
+	return Render(renderer, text)
+}
+
+// Render renders a markdown document with an existing renderer, without the surrounding margin.
+func Render(renderer *glamour.TermRenderer, text string) (string, error) {
+	rendered, err := renderer.Render(text)
+	if err != nil {
+		return text, err
+	}
+	return trimBlankLines(rendered), nil
+}
+
+// trimBlankLines removes the margin glamour adds above and below a document.
+func trimBlankLines(text string) string {
+	lines := strings.Split(text, "\n")
+	start, end := 0, len(lines)
+	for start < end && strings.TrimSpace(stripANSI(lines[start])) == "" {
+		start++
+	}
+	for end > start && strings.TrimSpace(stripANSI(lines[end-1])) == "" {
+		end--
+	}
+	return strings.Join(lines[start:end], "\n")
+}
+
+// stripANSI removes escape sequences, so styled blank lines can be detected.
+func stripANSI(text string) string {
+	var b strings.Builder
+	inEscape := false
+	for _, r := range text {
+		switch {
+		case r == '\x1b':
+			inEscape = true
+		case inEscape:
+			if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
+				inEscape = false
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,154 @@
+package resources
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	aiutil "github.com/ztkent/ai-util"
+	"github.com/ztkent/moki/internal/analyzer"
+	"github.com/ztkent/moki/internal/execute"
+)
+
+const (
+	// CommandTimeout is the longest a -cmd: resource can run, before it is killed.
+	CommandTimeout = time.Second * 30
+)
+
+// ConfirmFunc asks the user before a command is run.
+// It returns true if the command should run.
+type ConfirmFunc func(command string) (bool, error)
+
+// CommandOutput is the result of running a -cmd: resource.
+type CommandOutput struct {
+	Command  string
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	TimedOut bool
+	Timeout  time.Duration
+	Duration time.Duration
+}
+
+// RunCommand runs the command in the user's $SHELL, without a terminal.
+// Stdout and stderr are captured separately. A non-zero exit code is not an error,
+// but a command killed because ctx was cancelled is.
+func RunCommand(parent context.Context, command string, timeout time.Duration) (*CommandOutput, error) {
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, execute.Shell(), "-c", command)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	// Give the command a moment to exit after being killed, in case it left children holding the output open
+	cmd.WaitDelay = time.Second
+
+	start := time.Now()
+	err := cmd.Run()
+	if parent.Err() != nil {
+		return nil, parent.Err()
+	}
+	out := &CommandOutput{
+		Command:  command,
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		Timeout:  timeout,
+		Duration: time.Since(start),
+		TimedOut: ctx.Err() == context.DeadlineExceeded,
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		out.ExitCode = exitErr.ExitCode()
+	} else if err != nil && !out.TimedOut {
+		return nil, fmt.Errorf("Failed to run command: %w", err)
+	}
+	return out, nil
+}
+
+// String formats the output as a reference, labeling the exit status, stdout and stderr.
+func (o *CommandOutput) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Command: %s\n", o.Command)
+	if o.TimedOut {
+		fmt.Fprintf(&b, "Exit status: killed after timing out (%s)\n", o.Timeout)
+	} else {
+		fmt.Fprintf(&b, "Exit status: %d\n", o.ExitCode)
+	}
+	fmt.Fprintf(&b, "\nStdout:\n%s\n", orNone(o.Stdout))
+	fmt.Fprintf(&b, "\nStderr:\n%s", orNone(o.Stderr))
+	return b.String()
+}
+
+// Status is a short description of how the command ended, e.g. "exit 2".
+func (o *CommandOutput) Status() string {
+	if o.TimedOut {
+		return "timed out"
+	}
+	return fmt.Sprintf("exit %d", o.ExitCode)
+}
+
+// AddCommand runs the command once it is confirmed, and adds its output to the conversation as a reference.
+// It returns a summary of how the command ended, and the estimated tokens attached.
+func AddCommand(ctx context.Context, conv *aiutil.Conversation, command string, confirm ConfirmFunc, fit *Fit) (string, error) {
+	if !conv.ResourcesEnabled {
+		return "", fmt.Errorf("resource management is disabled for this conversation")
+	}
+	if command == "" {
+		return "", fmt.Errorf("Please provide a command, use -cmd:\"<command>\"")
+	}
+	if confirm == nil {
+		return "", fmt.Errorf("Commands can't be confirmed here, so %q was not run", command)
+	}
+	ok, err := confirm(command)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("Command cancelled: %s", command)
+	}
+
+	out, err := RunCommand(ctx, command, CommandTimeout)
+	if err != nil {
+		return "", err
+	}
+	tokens, err := fit.Attach(conv, "cmd "+command, out.String())
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s, ~%d tokens", out.Status(), tokens), nil
+}
+
+// ConfirmOnTerminal asks on the terminal before running a command, showing any warnings for it.
+// The answer is read from /dev/tty, so it works when stdin is piped.
+func ConfirmOnTerminal(command string) (bool, error) {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return false, fmt.Errorf("Failed to confirm the command, there is no terminal: %w", err)
+	}
+	defer tty.Close()
+
+	if warnings := analyzer.Analyze(command); len(warnings) > 0 {
+		analyzer.PrintWarnings(os.Stderr, warnings)
+	}
+	fmt.Fprintf(os.Stderr, "Run `%s` and attach its output? [y/N]: ", command)
+	answer, err := bufio.NewReader(tty).ReadString('\n')
+	if err != nil {
+		return false, fmt.Errorf("Failed to read the answer: %w", err)
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
+
+func orNone(text string) string {
+	if strings.TrimSpace(text) == "" {
+		return "(none)"
+	}
+	return strings.TrimRight(text, "\n")
+}
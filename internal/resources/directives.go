@@ -0,0 +1,132 @@
+package resources
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// DirectiveTypes are the resources that can be attached to a message, used as -<type>:<value>.
+var DirectiveTypes = []string{"url", "file", "dir", "glob", "git", "cmd"}
+
+// Directive is a resource attached to a message, e.g. -file:main.go
+type Directive struct {
+	Type  string
+	Value string
+}
+
+// String formats the directive as it would be typed, quoting the value if needed.
+func (d Directive) String() string {
+	return FormatDirective(d.Type, d.Value)
+}
+
+// FormatDirective formats a directive, quoting the value if it contains spaces, quotes or backslashes.
+func FormatDirective(resourceType string, value string) string {
+	if value != "" && !strings.ContainsFunc(value, func(r rune) bool { return unicode.IsSpace(r) || strings.ContainsRune(`"'\`, r) }) {
+		return "-" + resourceType + ":" + value
+	}
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value)
+	return "-" + resourceType + `:"` + escaped + `"`
+}
+
+// ParseDirectives finds the resource directives in a message, and returns the message without them.
+// Case is preserved. Values end at the first space, unless they are quoted with " or '.
+// In double quotes, and in unquoted values, a backslash escapes the next character, e.g. -file:"my \"notes\".txt" or -file:my\ notes.txt
+// A directive starting with a backslash, e.g. \-file:x, is kept in the message as -file:x.
+func ParseDirectives(input string) (string, []Directive, error) {
+	directives := []Directive{}
+	var message strings.Builder
+	runes := []rune(input)
+	for i := 0; i < len(runes); {
+		// Directives only start at the beginning of a word
+		atWord := i == 0 || unicode.IsSpace(runes[i-1])
+		if atWord && runes[i] == '\\' && i+1 < len(runes) && runes[i+1] == '-' {
+			if _, end, ok := directiveName(runes, i+1); ok {
+				message.WriteString(string(runes[i+1 : end]))
+				i = end
+				continue
+			}
+		}
+		if !atWord || runes[i] != '-' {
+			message.WriteRune(runes[i])
+			i++
+			continue
+		}
+		name, end, ok := directiveName(runes, i)
+		if !ok {
+			message.WriteRune(runes[i])
+			i++
+			continue
+		}
+		if !slices.Contains(DirectiveTypes, name) {
+			return input, nil, fmt.Errorf("Unknown resource -%s:, use one of: %s", name, strings.Join(directiveFormats(), ", "))
+		}
+		value, next, err := directiveValue(runes, end)
+		if err != nil {
+			return input, nil, fmt.Errorf("Invalid resource -%s: %w", name, err)
+		}
+		if value == "" {
+			return input, nil, fmt.Errorf("Missing a value for -%s:", name)
+		}
+		directives = append(directives, Directive{Type: name, Value: value})
+		i = next
+		// Drop the space the directive leaves behind, keeping any line breaks
+		if message.Len() == 0 || strings.HasSuffix(message.String(), " ") || strings.HasSuffix(message.String(), "\n") {
+			for i < len(runes) && (runes[i] == ' ' || runes[i] == '\t') {
+				i++
+			}
+		}
+	}
+	return strings.TrimSpace(message.String()), directives, nil
+}
+
+// directiveName reads the name of a directive starting at the dash, e.g. "file" from -file:
+// It returns the name, and the index after the colon.
+func directiveName(runes []rune, start int) (string, int, bool) {
+	i := start + 1
+	for i < len(runes) && unicode.IsLetter(runes[i]) {
+		i++
+	}
+	if i == start+1 || i >= len(runes) || runes[i] != ':' {
+		return "", 0, false
+	}
+	return strings.ToLower(string(runes[start+1 : i])), i + 1, true
+}
+
+// directiveValue reads the value of a directive, unquoting and unescaping it.
+// It returns the value, and the index after it.
+func directiveValue(runes []rune, start int) (string, int, error) {
+	var value strings.Builder
+	i := start
+	if i < len(runes) && (runes[i] == '"' || runes[i] == '\'') {
+		quote := runes[i]
+		for i++; i < len(runes); i++ {
+			switch {
+			case runes[i] == quote:
+				return value.String(), i + 1, nil
+			case runes[i] == '\\' && quote == '"' && i+1 < len(runes):
+				i++
+				value.WriteRune(runes[i])
+			default:
+				value.WriteRune(runes[i])
+			}
+		}
+		return "", 0, fmt.Errorf("missing the closing %c", quote)
+	}
+	for ; i < len(runes) && !unicode.IsSpace(runes[i]); i++ {
+		if runes[i] == '\\' && i+1 < len(runes) {
+			i++
+		}
+		value.WriteRune(runes[i])
+	}
+	return value.String(), i, nil
+}
+
+func directiveFormats() []string {
+	formats := make([]string, 0, len(DirectiveTypes))
+	for _, t := range DirectiveTypes {
+		formats = append(formats, "-"+t+":")
+	}
+	return formats
+}
@@ -0,0 +1,183 @@
+package resources
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDirectives(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      string
+		message    string
+		directives []Directive
+		err        string
+	}{
+		{
+			name:       "no directives",
+			input:      "explain this code",
+			message:    "explain this code",
+			directives: []Directive{},
+		},
+		{
+			name:       "at the start",
+			input:      "-file:main.go explain this",
+			message:    "explain this",
+			directives: []Directive{{Type: "file", Value: "main.go"}},
+		},
+		{
+			name:       "in the middle",
+			input:      "explain -file:main.go in detail",
+			message:    "explain in detail",
+			directives: []Directive{{Type: "file", Value: "main.go"}},
+		},
+		{
+			name:       "at the end",
+			input:      "explain this -file:main.go",
+			message:    "explain this",
+			directives: []Directive{{Type: "file", Value: "main.go"}},
+		},
+		{
+			name:    "several files",
+			input:   "compare -file:a.go -file:b.go -file:c.go",
+			message: "compare",
+			directives: []Directive{
+				{Type: "file", Value: "a.go"},
+				{Type: "file", Value: "b.go"},
+				{Type: "file", Value: "c.go"},
+			},
+		},
+		{
+			name:    "mixed types",
+			input:   "-url:https://example.com summarize -git:diff",
+			message: "summarize",
+			directives: []Directive{
+				{Type: "url", Value: "https://example.com"},
+				{Type: "git", Value: "diff"},
+			},
+		},
+		{
+			name:       "double quoted value with spaces",
+			input:      `read -file:"my notes.txt" please`,
+			message:    "read please",
+			directives: []Directive{{Type: "file", Value: "my notes.txt"}},
+		},
+		{
+			name:       "single quoted value with spaces",
+			input:      `-cmd:'ls -la' what is here`,
+			message:    "what is here",
+			directives: []Directive{{Type: "cmd", Value: "ls -la"}},
+		},
+		{
+			name:       "escaped quotes",
+			input:      `-file:"my \"notes\".txt"`,
+			message:    "",
+			directives: []Directive{{Type: "file", Value: `my "notes".txt`}},
+		},
+		{
+			name:       "escaped space",
+			input:      `-file:my\ notes.txt`,
+			message:    "",
+			directives: []Directive{{Type: "file", Value: "my notes.txt"}},
+		},
+		{
+			name:       "type is case insensitive",
+			input:      "-FILE:Main.go",
+			message:    "",
+			directives: []Directive{{Type: "file", Value: "Main.go"}},
+		},
+		{
+			name:       "escaped directive stays in the message",
+			input:      `what does \-file:x mean`,
+			message:    "what does -file:x mean",
+			directives: []Directive{},
+		},
+		{
+			name:       "not at the start of a word",
+			input:      "a-file:x and --flag",
+			message:    "a-file:x and --flag",
+			directives: []Directive{},
+		},
+		{
+			name:       "keeps line breaks",
+			input:      "first line\n-file:a.go\nsecond line",
+			message:    "first line\n\nsecond line",
+			directives: []Directive{{Type: "file", Value: "a.go"}},
+		},
+		{
+			name:  "unknown type",
+			input: "read -pdf:doc.pdf",
+			err:   "Unknown resource -pdf:",
+		},
+		{
+			name:  "unterminated double quote",
+			input: `read -file:"my notes.txt`,
+			err:   "missing the closing \"",
+		},
+		{
+			name:  "unterminated single quote",
+			input: `read -file:'my notes.txt`,
+			err:   "missing the closing '",
+		},
+		{
+			name:  "empty value",
+			input: "read -file: please",
+			err:   "Missing a value for -file:",
+		},
+		{
+			name:  "empty quoted value",
+			input: `read -file:"" please`,
+			err:   "Missing a value for -file:",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message, directives, err := ParseDirectives(tt.input)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("ParseDirectives(%q) error = %v, want %q", tt.input, err, tt.err)
+				}
+				if message != tt.input {
+					t.Errorf("ParseDirectives(%q) message = %q, want the input back", tt.input, message)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseDirectives(%q) error = %v", tt.input, err)
+			}
+			if message != tt.message {
+				t.Errorf("ParseDirectives(%q) message = %q, want %q", tt.input, message, tt.message)
+			}
+			if !reflect.DeepEqual(directives, tt.directives) {
+				t.Errorf("ParseDirectives(%q) directives = %v, want %v", tt.input, directives, tt.directives)
+			}
+		})
+	}
+}
+
+func TestFormatDirective(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"main.go", "-file:main.go"},
+		{"my notes.txt", `-file:"my notes.txt"`},
+		{`say "hi".txt`, `-file:"say \"hi\".txt"`},
+		{`C:\dir`, `-file:"C:\\dir"`},
+		{"", `-file:""`},
+	}
+	for _, tt := range tests {
+		if got := FormatDirective("file", tt.value); got != tt.want {
+			t.Errorf("FormatDirective(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+		if tt.value == "" {
+			continue
+		}
+		// A formatted directive parses back to the same value
+		_, directives, err := ParseDirectives(FormatDirective("file", tt.value))
+		if err != nil || len(directives) != 1 || directives[0].Value != tt.value {
+			t.Errorf("ParseDirectives(FormatDirective(%q)) = %v, %v", tt.value, directives, err)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package resources
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	aiutil "github.com/ztkent/ai-util"
+	"github.com/ztkent/moki/internal/tokenizer"
+)
+
+const (
+	// MaxFileSize is the largest file attached from a directory or glob, larger files are skipped.
+	MaxFileSize = 100 * 1024
+	// MaxFiles is the most files a single directory or glob attaches.
+	MaxFiles = 200
+	// sniffLength is how much of a file is read to decide if it is binary.
+	sniffLength = 8000
+)
+
+// File is a text file attached to a conversation.
+type File struct {
+	Path    string
+	Content string
+	Tokens  int
+}
+
+// Skipped is a file that matched, but wasn't attached.
+type Skipped struct {
+	Path   string
+	Reason string
+}
+
+// Files are the files matched by a directory or glob resource.
+type Files struct {
+	Files   []File
+	Skipped []Skipped
+}
+
+// Dir collects the text files under a directory.
+// In a git repository, files ignored by git are left out.
+func Dir(root string) (*Files, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read directory %s: %w", root, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("Not a directory: %s", root)
+	}
+	paths, err := listFiles(root)
+	if err != nil {
+		return nil, err
+	}
+	return readFiles(paths), nil
+}
+
+// Glob collects the text files that match a pattern.
+// Patterns follow path.Match, and ** matches any number of directories, e.g. internal/**/*.go
+// In a git repository, files ignored by git are left out.
+func Glob(pattern string) (*Files, error) {
+	pattern = filepath.ToSlash(filepath.Clean(pattern))
+	root := globRoot(pattern)
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return nil, fmt.Errorf("No files match %s", pattern)
+	}
+	paths, err := listFiles(root)
+	if err != nil {
+		return nil, err
+	}
+	matched := []string{}
+	for _, p := range paths {
+		if MatchGlob(pattern, filepath.ToSlash(p)) {
+			matched = append(matched, p)
+		}
+	}
+	if len(matched) == 0 {
+		return nil, fmt.Errorf("No files match %s", pattern)
+	}
+	return readFiles(matched), nil
+}
+
+// MatchGlob reports whether the slash separated name matches the pattern.
+// It follows path.Match, with ** matching any number of directories.
+func MatchGlob(pattern string, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern []string, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Try every number of directories, including none
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// globRoot is the directory before the first wildcard in the pattern.
+func globRoot(pattern string) string {
+	segments := strings.Split(pattern, "/")
+	for i, segment := range segments {
+		if strings.ContainsAny(segment, `*?[\`) {
+			if i == 0 {
+				return "."
+			}
+			root := strings.Join(segments[:i], "/")
+			if root == "" {
+				return "/"
+			}
+			return root
+		}
+	}
+	return pattern
+}
+
+// listFiles lists the files under root.
+// In a git repository, git lists them, so .gitignore is respected.
+// Otherwise every file is listed, except in hidden directories.
+func listFiles(root string) ([]string, error) {
+	if paths, ok := gitFiles(root); ok {
+		return paths, nil
+	}
+	paths := []string{}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != root && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() {
+			paths = append(paths, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list files in %s: %w", root, err)
+	}
+	return paths, nil
+}
+
+// gitFiles lists the tracked and untracked files under root, leaving out the ones git ignores.
+func gitFiles(root string) ([]string, bool) {
+	cmd := exec.Command("git", "ls-files", "--cached", "--others", "--exclude-standard", "-z", "--", ".")
+	cmd.Dir = root
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, false
+	}
+	paths := []string{}
+	for _, p := range strings.Split(string(out), "\x00") {
+		if p == "" {
+			continue
+		}
+		// Deleted files are still listed until the deletion is staged
+		full := filepath.Join(root, p)
+		if info, err := os.Lstat(full); err == nil && info.Mode().IsRegular() {
+			paths = append(paths, full)
+		}
+	}
+	return paths, true
+}
+
+// readFiles reads the text files, skipping binary, empty and oversized ones.
+func readFiles(paths []string) *Files {
+	files := &Files{}
+	for _, p := range paths {
+		if len(files.Files) >= MaxFiles {
+			files.Skipped = append(files.Skipped, Skipped{Path: p, Reason: fmt.Sprintf("more than %d files", MaxFiles)})
+			continue
+		}
+		content, reason := readText(p)
+		if reason != "" {
+			files.Skipped = append(files.Skipped, Skipped{Path: p, Reason: reason})
+			continue
+		}
+		files.Files = append(files.Files, File{Path: p, Content: content, Tokens: tokenizer.Count(content)})
+	}
+	return files
+}
+
+// readText reads a text file, or returns the reason it was skipped.
+func readText(p string) (string, string) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return "", "unreadable"
+	}
+	switch {
+	case info.Size() == 0:
+		return "", "empty"
+	case info.Size() > MaxFileSize:
+		return "", fmt.Sprintf("larger than %d KB", MaxFileSize/1024)
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return "", "unreadable"
+	}
+	defer f.Close()
+	content, err := io.ReadAll(f)
+	if err != nil {
+		return "", "unreadable"
+	}
+	if isBinary(content) {
+		return "", "binary"
+	}
+	return string(content), ""
+}
+
+// isBinary reports whether the content looks binary, by checking the start for NUL bytes or invalid UTF-8.
+func isBinary(content []byte) bool {
+	sniff := content[:min(len(content), sniffLength)]
+	if bytes.IndexByte(sniff, 0) >= 0 {
+		return true
+	}
+	// A multi-byte character may be cut off at the end of the sniffed bytes
+	for i := 0; i < utf8.UTFMax && len(sniff) > 0 && !utf8.Valid(sniff); i++ {
+		sniff = sniff[:len(sniff)-1]
+	}
+	return !utf8.Valid(sniff)
+}
+
+// Tokens is the estimated number of tokens in the attached files.
+func (f *Files) Tokens() int {
+	total := 0
+	for _, file := range f.Files {
+		total += file.Tokens
+	}
+	return total
+}
+
+// Summary describes the attached files, e.g. "12 files, ~3400 tokens, 2 skipped".
+func (f *Files) Summary() string {
+	summary := fmt.Sprintf("%d files, ~%d tokens", len(f.Files), f.Tokens())
+	if len(f.Skipped) > 0 {
+		summary += fmt.Sprintf(", %d skipped", len(f.Skipped))
+	}
+	return summary
+}
+
+// AddFiles adds each file to the conversation as a reference, with its path as a header.
+// Files are cut down to fit, once there is no room left the rest are skipped.
+func AddFiles(conv *aiutil.Conversation, files *Files, fit *Fit) error {
+	if !conv.ResourcesEnabled {
+		return fmt.Errorf("resource management is disabled for this conversation")
+	}
+	if len(files.Files) == 0 {
+		return fmt.Errorf("No text files to attach, %d skipped", len(files.Skipped))
+	}
+	for i, file := range files.Files {
+		content := fmt.Sprintf("File: %s\n\n%s", filepath.ToSlash(file.Path), file.Content)
+		if _, err := fit.Attach(conv, filepath.ToSlash(file.Path), content); err != nil {
+			if i == 0 {
+				return err
+			}
+			fit.Warnings = append(fit.Warnings, fmt.Sprintf("There is no room left in the conversation, %d of %d files were not attached", len(files.Files)-i, len(files.Files)))
+			return nil
+		}
+	}
+	return nil
+}
+
+// AddFile adds a single text file to the conversation as a reference.
+// It returns a summary of the estimated tokens attached.
+func AddFile(conv *aiutil.Conversation, path string, fit *Fit) (string, error) {
+	if !conv.ResourcesEnabled {
+		return "", fmt.Errorf("resource management is disabled for this conversation")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read file %s: %w", path, err)
+	}
+	switch {
+	case info.IsDir():
+		return "", fmt.Errorf("%s is a directory, use -dir:%s", path, path)
+	case info.Size() == 0:
+		return "", fmt.Errorf("File is empty: %s", path)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read file %s: %w", path, err)
+	}
+	if isBinary(content) {
+		return "", fmt.Errorf("%s is a binary file", path)
+	}
+	tokens, err := fit.Attach(conv, path, string(content))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("~%d tokens", tokens), nil
+}
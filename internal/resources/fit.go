@@ -0,0 +1,59 @@
+package resources
+
+import (
+	"fmt"
+
+	aiutil "github.com/ztkent/ai-util"
+	"github.com/ztkent/moki/internal/tokenizer"
+)
+
+const (
+	// ReserveTokens are kept free in the conversation for the question and the answer.
+	// Small conversations keep a quarter of their budget instead.
+	ReserveTokens = 4096
+	// referenceTokens covers the tags each reference is wrapped in.
+	referenceTokens = 32
+	// minTokens is the smallest part of a resource worth attaching.
+	minTokens = 64
+)
+
+// Fit limits the size of the resources attached to a conversation.
+// Resources that don't fit are cut down with the strategy, and each cut is added to the warnings.
+type Fit struct {
+	// Strategy chooses what is kept of a resource that doesn't fit
+	Strategy tokenizer.Strategy
+	// MaxTokens caps a single resource, if it is 0 a resource can fill the space left in the conversation
+	MaxTokens int
+	// Query is the question, the relevant strategy keeps the parts that share its words
+	Query string
+	// Warnings describe what was cut from each resource
+	Warnings []string
+}
+
+// Attach adds the content to the conversation as a reference, cutting it down if it doesn't fit.
+// It returns the estimated tokens attached.
+func (f *Fit) Attach(conv *aiutil.Conversation, id string, content string) (int, error) {
+	limit := f.space(conv)
+	if f.MaxTokens > 0 {
+		limit = min(limit, f.MaxTokens)
+	}
+	if limit < minTokens {
+		return 0, fmt.Errorf("There is no room left in the conversation for %s", id)
+	}
+	content, cut := tokenizer.Truncate(content, limit, f.Strategy, f.Query)
+	if cut != nil {
+		f.Warnings = append(f.Warnings, fmt.Sprintf("%s was cut to fit, %s", id, cut))
+	}
+	if err := conv.AddReference(id, content); err != nil {
+		return 0, err
+	}
+	return tokenizer.Count(content), nil
+}
+
+// space is the number of tokens a reference can use, leaving room for the question and answer.
+func (f *Fit) space(conv *aiutil.Conversation) int {
+	conv.Lock()
+	defer conv.Unlock()
+	reserve := min(ReserveTokens, conv.MaxTokens/4)
+	return conv.MaxTokens - conv.TokenCount - reserve - referenceTokens
+}
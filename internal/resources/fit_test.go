@@ -0,0 +1,94 @@
+package resources
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	aiutil "github.com/ztkent/ai-util"
+	"github.com/ztkent/moki/internal/tokenizer"
+)
+
+func lines(n int) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, "line %d of the resource\n", i)
+	}
+	return b.String()
+}
+
+func TestFitAttach(t *testing.T) {
+	conv := aiutil.NewConversation("system", 10000, true)
+	fit := &Fit{Strategy: tokenizer.Head}
+	tokens, err := fit.Attach(conv, "file:small.txt", "small file")
+	if err != nil || tokens != tokenizer.Count("small file") {
+		t.Fatalf("Attach = %d, %v, want the small file attached", tokens, err)
+	}
+	if len(fit.Warnings) != 0 {
+		t.Errorf("warnings = %v, want none for a resource that fits", fit.Warnings)
+	}
+	last := conv.Messages[len(conv.Messages)-1]
+	if last.Role != openai.ChatMessageRoleSystem || !strings.Contains(last.Content, `<Reference id="file:small.txt">`) {
+		t.Errorf("last message = %+v, want the reference", last)
+	}
+}
+
+func TestFitSpace(t *testing.T) {
+	content := lines(5000)
+	tests := []struct {
+		name      string
+		convMax   int
+		maxTokens int
+		want      int
+	}{
+		// The conversation keeps ReserveTokens free, and room for the reference tags
+		{"zero max tokens fills the conversation", 20000, 0, 20000 - 1 - ReserveTokens - referenceTokens},
+		{"max tokens caps the resource", 20000, 1000, 1000},
+		{"max tokens over the space left", 20000, 50000, 20000 - 1 - ReserveTokens - referenceTokens},
+		// Small conversations keep a quarter of their budget free
+		{"small conversation", 4000, 0, 4000 - 1 - 1000 - referenceTokens},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conv := aiutil.NewConversation("system", tt.convMax, true)
+			fit := &Fit{Strategy: tokenizer.Head, MaxTokens: tt.maxTokens}
+			if space := fit.space(conv); tt.maxTokens == 0 && space != tt.want {
+				t.Errorf("space = %d, want %d", space, tt.want)
+			}
+			tokens, err := fit.Attach(conv, "file:big.txt", content)
+			if err != nil {
+				t.Fatalf("Attach: %v", err)
+			}
+			if tokens > tt.want || tokens < tt.want-50 {
+				t.Errorf("attached %d tokens, want about %d", tokens, tt.want)
+			}
+			if len(fit.Warnings) != 1 || !strings.HasPrefix(fit.Warnings[0], "file:big.txt was cut to fit, kept ") {
+				t.Errorf("warnings = %v, want the cut described", fit.Warnings)
+			}
+		})
+	}
+}
+
+func TestFitFullConversation(t *testing.T) {
+	conv := aiutil.NewConversation("system", 5000, true)
+	fit := &Fit{Strategy: tokenizer.HeadTail}
+	// Fill the conversation up to its reserve
+	if _, err := fit.Attach(conv, "file:first.txt", lines(5000)); err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+	before := len(conv.Messages)
+	if _, err := fit.Attach(conv, "file:second.txt", "more"); err == nil || !strings.Contains(err.Error(), "no room left") {
+		t.Errorf("Attach to a full conversation = %v, want no room left", err)
+	}
+	if len(conv.Messages) != before {
+		t.Errorf("messages = %d, want %d, nothing attached", len(conv.Messages), before)
+	}
+
+	// A conversation already over its budget has no room either
+	conv = aiutil.NewConversation("system", 5000, true)
+	conv.TokenCount = 6000
+	if _, err := fit.Attach(conv, "file:third.txt", "more"); err == nil {
+		t.Error("Attach to a conversation over its budget succeeded, want an error")
+	}
+}
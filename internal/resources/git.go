@@ -0,0 +1,106 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	aiutil "github.com/ztkent/ai-util"
+)
+
+const (
+	// GitTimeout is the longest a git resource can take to read.
+	GitTimeout = time.Second * 10
+	// DefaultLogCount is the number of commits -git:log attaches.
+	DefaultLogCount = 10
+)
+
+// GitResources are the git resources, used as -git:<resource>.
+var GitResources = []string{"diff", "staged", "log[:N]", "show:<rev>", "blame:<file>"}
+
+// Git reads a git resource from the repository in the current directory.
+// The resource is one of diff, staged, log[:N], show:<rev> or blame:<file>.
+// It returns a label for the reference, and the output of git.
+func Git(ctx context.Context, resource string) (string, string, error) {
+	name, arg, _ := strings.Cut(resource, ":")
+	var args []string
+	switch name {
+	case "diff":
+		args = []string{"diff"}
+	case "staged":
+		args = []string{"diff", "--cached"}
+	case "log":
+		count := DefaultLogCount
+		if arg != "" {
+			n, err := strconv.Atoi(arg)
+			if err != nil || n <= 0 {
+				return "", "", fmt.Errorf("Invalid log count %q, use -git:log:N", arg)
+			}
+			count = n
+		}
+		args = []string{"log", "-n", strconv.Itoa(count), "--stat"}
+	case "show":
+		// Revisions can't start with a dash, or they would be read as flags
+		if arg == "" || strings.HasPrefix(arg, "-") {
+			return "", "", fmt.Errorf("Invalid revision %q, use -git:show:<rev>", arg)
+		}
+		args = []string{"show", "--stat", "--patch", arg, "--"}
+	case "blame":
+		if arg == "" {
+			return "", "", fmt.Errorf("Please provide a file, use -git:blame:<file>")
+		}
+		args = []string{"blame", "--", arg}
+	default:
+		return "", "", fmt.Errorf("Unknown git resource %q, use one of: %s", name, strings.Join(GitResources, ", "))
+	}
+
+	out, err := runGit(ctx, args...)
+	if err != nil {
+		return "", "", err
+	}
+	if strings.TrimSpace(out) == "" {
+		switch name {
+		case "diff":
+			return "", "", fmt.Errorf("There are no unstaged changes")
+		case "staged":
+			return "", "", fmt.Errorf("There are no staged changes")
+		}
+		return "", "", fmt.Errorf("git %s returned nothing", strings.Join(args, " "))
+	}
+	return "git " + resource, out, nil
+}
+
+// AddGit adds a git resource to the conversation as a reference.
+// It returns a summary of the estimated tokens attached.
+func AddGit(ctx context.Context, conv *aiutil.Conversation, resource string, fit *Fit) (string, error) {
+	if !conv.ResourcesEnabled {
+		return "", fmt.Errorf("resource management is disabled for this conversation")
+	}
+	label, content, err := Git(ctx, resource)
+	if err != nil {
+		return "", err
+	}
+	tokens, err := fit.Attach(conv, label, content)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("~%d tokens", tokens), nil
+}
+
+// runGit runs git without a pager or colors, and returns its output.
+func runGit(ctx context.Context, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, GitTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "git", append([]string{"--no-pager", "-c", "color.ui=never"}, args...)...)
+	out, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("git %s failed: %s", args[0], strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", fmt.Errorf("git %s failed: %w", args[0], err)
+	}
+	return string(out), nil
+}
@@ -0,0 +1,76 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	aiutil "github.com/ztkent/ai-util"
+)
+
+const (
+	// URLTimeout is the longest a web page can take to fetch.
+	URLTimeout = time.Second * 15
+	// MaxURLSize is the most of a web page that is read, the rest is ignored.
+	MaxURLSize = 5 * 1024 * 1024
+)
+
+// URL fetches a web page, and returns its text.
+func URL(ctx context.Context, rawURL string) (string, error) {
+	parsed, err := url.ParseRequestURI(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return "", fmt.Errorf("Invalid URL %s, only http and https are supported", rawURL)
+	}
+	client := http.Client{Timeout: URLTimeout}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, parsed.String(), nil)
+	if err != nil {
+		return "", fmt.Errorf("Failed to fetch %s: %w", rawURL, err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("Failed to fetch %s: %w", rawURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("Failed to fetch %s: %s", rawURL, resp.Status)
+	}
+
+	doc, err := goquery.NewDocumentFromReader(io.LimitReader(resp.Body, MaxURLSize))
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse %s: %w", rawURL, err)
+	}
+	// Drop the parts of the page that aren't text
+	doc.Find("script, style, noscript").Remove()
+	var text strings.Builder
+	doc.Find("body").Each(func(i int, s *goquery.Selection) {
+		text.WriteString(strings.Join(strings.Fields(s.Text()), " "))
+		text.WriteString("\n")
+	})
+	content := strings.TrimSpace(text.String())
+	if content == "" {
+		return "", fmt.Errorf("There is no text on %s", rawURL)
+	}
+	return content, nil
+}
+
+// AddURL adds the text of a web page to the conversation as a reference.
+// It returns a summary of the estimated tokens attached.
+func AddURL(ctx context.Context, conv *aiutil.Conversation, rawURL string, fit *Fit) (string, error) {
+	if !conv.ResourcesEnabled {
+		return "", fmt.Errorf("resource management is disabled for this conversation")
+	}
+	content, err := URL(ctx, rawURL)
+	if err != nil {
+		return "", err
+	}
+	tokens, err := fit.Attach(conv, rawURL, content)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("~%d tokens", tokens), nil
+}
@@ -0,0 +1,260 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	aiutil "github.com/ztkent/ai-util"
+)
+
+// Session is a conversation saved to disk, so it can be resumed later.
+type Session struct {
+	ID               string                         `json:"id"`
+	CreatedAt        time.Time                      `json:"created_at"`
+	UpdatedAt        time.Time                      `json:"updated_at"`
+	Provider         string                         `json:"provider"`
+	Model            string                         `json:"model"`
+	MaxTokens        int                            `json:"max_tokens"`
+	ResourcesEnabled bool                           `json:"resources_enabled"`
+	TokenCount       int                            `json:"token_count"`
+	Messages         []openai.ChatCompletionMessage `json:"messages"`
+	// Pinned are the indexes of the messages kept verbatim when the history is compacted
+	Pinned []int `json:"pinned,omitempty"`
+	store  *Store
+}
+
+// Store keeps sessions as JSON files in a single directory.
+type Store struct {
+	Dir string
+}
+
+// DefaultDir returns the session directory under the XDG data home.
+// $XDG_DATA_HOME/moki/sessions, or ~/.local/share/moki/sessions if it is unset.
+func DefaultDir() (string, error) {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("Failed to find the home directory: %w", err)
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dataHome, "moki", "sessions"), nil
+}
+
+// NewStore opens the session store at dir, creating it if needed.
+// If dir is empty, the DefaultDir is used.
+func NewStore(dir string) (*Store, error) {
+	if dir == "" {
+		defaultDir, err := DefaultDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = defaultDir
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("Failed to create session directory %s: %w", dir, err)
+	}
+	return &Store{Dir: dir}, nil
+}
+
+// New creates a session for the conversation, without saving it.
+func (s *Store) New(client aiutil.Client, conv *aiutil.Conversation) (*Session, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	sess := &Session{
+		ID:        id,
+		CreatedAt: now,
+		UpdatedAt: now,
+		store:     s,
+	}
+	if client != nil {
+		sess.Provider = client.GetConfig().Provider
+		sess.Model = client.GetConfig().Model
+	}
+	sess.update(conv)
+	return sess, nil
+}
+
+// Save writes the session to disk.
+func (s *Store) Save(sess *Session) error {
+	if err := validateID(sess.ID); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(sess, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Failed to encode session %s: %w", sess.ID, err)
+	}
+
+	// Write to a temp file first, so a crash never leaves a half-written session
+	tmp, err := os.CreateTemp(s.Dir, sess.ID+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("Failed to save session %s: %w", sess.ID, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Failed to save session %s: %w", sess.ID, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("Failed to save session %s: %w", sess.ID, err)
+	}
+	if err := os.Rename(tmp.Name(), s.path(sess.ID)); err != nil {
+		return fmt.Errorf("Failed to save session %s: %w", sess.ID, err)
+	}
+	sess.store = s
+	return nil
+}
+
+// Load reads a session from disk.
+func (s *Store) Load(id string) (*Session, error) {
+	if err := validateID(id); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(s.path(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("Session not found: %s", id)
+		}
+		return nil, fmt.Errorf("Failed to read session %s: %w", id, err)
+	}
+	sess := &Session{}
+	if err := json.Unmarshal(data, sess); err != nil {
+		return nil, fmt.Errorf("Failed to decode session %s: %w", id, err)
+	}
+	sess.store = s
+	return sess, nil
+}
+
+// List returns all saved sessions, most recently updated first.
+// Sessions that can't be read are skipped, and returned as errors alongside the rest.
+func (s *Store) List() ([]*Session, []error, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to list sessions: %w", err)
+	}
+	sessions := []*Session{}
+	skipped := []error{}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		sess, err := s.Load(strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil {
+			skipped = append(skipped, err)
+			continue
+		}
+		sessions = append(sessions, sess)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].UpdatedAt.After(sessions[j].UpdatedAt)
+	})
+	return sessions, skipped, nil
+}
+
+// Delete removes a session from disk.
+func (s *Store) Delete(id string) error {
+	if err := validateID(id); err != nil {
+		return err
+	}
+	if err := os.Remove(s.path(id)); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("Session not found: %s", id)
+		}
+		return fmt.Errorf("Failed to delete session %s: %w", id, err)
+	}
+	return nil
+}
+
+func (s *Store) path(id string) string {
+	return filepath.Join(s.Dir, id+".json")
+}
+
+// Save copies the conversation history into the session and writes it to disk.
+func (sess *Session) Save(conv *aiutil.Conversation) error {
+	if sess.store == nil {
+		return fmt.Errorf("Failed to save session %s: no store", sess.ID)
+	}
+	sess.update(conv)
+	sess.UpdatedAt = time.Now()
+	return sess.store.Save(sess)
+}
+
+// Conversation rebuilds an aiutil.Conversation from the saved history.
+func (sess *Session) Conversation() *aiutil.Conversation {
+	systemPrompt := ""
+	if len(sess.Messages) > 0 && sess.Messages[0].Role == openai.ChatMessageRoleSystem {
+		systemPrompt = sess.Messages[0].Content
+	}
+	conv := aiutil.NewConversation(systemPrompt, sess.MaxTokens, sess.ResourcesEnabled)
+	if len(sess.Messages) > 0 {
+		conv.Messages = append([]openai.ChatCompletionMessage{}, sess.Messages...)
+		conv.TokenCount = sess.TokenCount
+	}
+	return conv
+}
+
+// MessageCount is the number of user and assistant messages, leaving out the system prompt and resources.
+func (sess *Session) MessageCount() int {
+	count := 0
+	for _, m := range sess.Messages {
+		if m.Role == openai.ChatMessageRoleUser || m.Role == openai.ChatMessageRoleAssistant {
+			count++
+		}
+	}
+	return count
+}
+
+// Title is a short description of the session, taken from the first user message.
+func (sess *Session) Title() string {
+	for _, m := range sess.Messages {
+		if m.Role == openai.ChatMessageRoleUser {
+			// Cut by runes, so a multi-byte character isn't split
+			title := []rune(strings.Join(strings.Fields(m.Content), " "))
+			if len(title) > 60 {
+				return string(title[:57]) + "..."
+			}
+			return string(title)
+		}
+	}
+	return "(empty)"
+}
+
+func (sess *Session) update(conv *aiutil.Conversation) {
+	if conv == nil {
+		return
+	}
+	conv.Lock()
+	defer conv.Unlock()
+	sess.Messages = append([]openai.ChatCompletionMessage{}, conv.Messages...)
+	sess.TokenCount = conv.TokenCount
+	sess.MaxTokens = conv.MaxTokens
+	sess.ResourcesEnabled = conv.ResourcesEnabled
+}
+
+func newID() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("Failed to generate session id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Session ids are used as file names, so they can't contain a path
+func validateID(id string) error {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return fmt.Errorf("Invalid session id: %q", id)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/sashabaranov/go-openai"
+	aiutil "github.com/ztkent/ai-util"
+)
+
+func TestStore(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conv := aiutil.NewConversation("system", 10000, true)
+	if err := conv.AddReference("file:notes.txt", "remember the milk"); err != nil {
+		t.Fatal(err)
+	}
+	conv.Append(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: "what should I buy?"})
+	conv.Append(openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: "milk"})
+
+	sess, err := store.New(nil, conv)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := store.Save(sess); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	loaded, err := store.Load(sess.ID)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(loaded.Messages) != 4 || loaded.Title() != "what should I buy?" {
+		t.Errorf("loaded %v, want the conversation back", loaded.Messages)
+	}
+	// The system prompt and resources aren't counted
+	if count := loaded.MessageCount(); count != 2 {
+		t.Errorf("MessageCount() = %d, want 2", count)
+	}
+}
+
+func TestListSkipsUnreadableSessions(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess, err := store.New(nil, aiutil.NewConversation("system", 10000, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(sess); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(store.Dir, "broken.json"), []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, skipped, err := store.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != sess.ID {
+		t.Errorf("sessions = %v, want the readable session", sessions)
+	}
+	if len(skipped) != 1 || !strings.Contains(skipped[0].Error(), "broken") {
+		t.Errorf("skipped = %v, want the broken session", skipped)
+	}
+}
+
+func TestTitle(t *testing.T) {
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{"  list   all\nfiles ", "list all files"},
+		{strings.Repeat("a", 60), strings.Repeat("a", 60)},
+		{strings.Repeat("a", 61), strings.Repeat("a", 57) + "..."},
+		// Multi-byte characters are counted, and cut, whole
+		{strings.Repeat("é", 60), strings.Repeat("é", 60)},
+		{strings.Repeat("日本", 40), strings.Repeat("日本", 28) + "日..."},
+	}
+	for _, tt := range tests {
+		sess := &Session{Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: tt.content}}}
+		if got := sess.Title(); got != tt.want || !utf8.ValidString(got) {
+			t.Errorf("Title() of %q = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+	if got := (&Session{}).Title(); got != "(empty)" {
+		t.Errorf("Title() of an empty session = %q, want (empty)", got)
+	}
+}
@@ -0,0 +1,72 @@
+package shell
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The snippets bind Ctrl-G to send the current command line to moki.
+// The command line is replaced with the suggested command, it is never run.
+const (
+	BashSnippet = `# moki shell integration for bash
+# Add to ~/.bashrc: eval "$(moki shell-init bash)"
+__moki_replace_line() {
+  [ -z "$READLINE_LINE" ] && return
+  local result
+  result=$(command moki -x=false -o raw -- "$READLINE_LINE" </dev/null 2>/dev/null) || return
+  if [ -n "$result" ]; then
+    READLINE_LINE="$result"
+    READLINE_POINT=${#READLINE_LINE}
+  fi
+}
+bind -x '"\C-g": __moki_replace_line'
+`
+
+	ZshSnippet = `# moki shell integration for zsh
+# Add to ~/.zshrc: eval "$(moki shell-init zsh)"
+__moki_replace_buffer() {
+  [[ -z "$BUFFER" ]] && return
+  local result
+  zle -R "moki: thinking..."
+  result=$(command moki -x=false -o raw -- "$BUFFER" </dev/null 2>/dev/null) || { zle reset-prompt; return }
+  if [[ -n "$result" ]]; then
+    BUFFER="$result"
+    CURSOR=${#BUFFER}
+  fi
+  zle reset-prompt
+}
+zle -N __moki_replace_buffer
+bindkey '^G' __moki_replace_buffer
+`
+
+	FishSnippet = `# moki shell integration for fish
+# Add to ~/.config/fish/config.fish: moki shell-init fish | source
+function __moki_replace_commandline
+    set -l buffer (commandline | string collect)
+    test -z "$buffer"; and return
+    set -l result (command moki -x=false -o raw -- $buffer </dev/null 2>/dev/null); or return
+    if test -n "$result"
+        commandline -r -- (string join \n -- $result)
+    end
+    commandline -f repaint
+end
+bind \cg __moki_replace_commandline
+`
+)
+
+// Shells lists the supported shells.
+var Shells = []string{"bash", "zsh", "fish"}
+
+// Snippet returns the integration snippet for the shell.
+func Snippet(shell string) (string, error) {
+	switch strings.ToLower(shell) {
+	case "bash":
+		return BashSnippet, nil
+	case "zsh":
+		return ZshSnippet, nil
+	case "fish":
+		return FishSnippet, nil
+	default:
+		return "", fmt.Errorf("Unsupported shell: %q (supported: %s)", shell, strings.Join(Shells, ", "))
+	}
+}
@@ -0,0 +1,288 @@
+package tokenizer
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+// Strategy decides which part of a resource is kept, when it doesn't fit.
+type Strategy string
+
+const (
+	// Head keeps the start.
+	Head Strategy = "head"
+	// Tail keeps the end, e.g. for logs.
+	Tail Strategy = "tail"
+	// HeadTail keeps the start and the end, cutting the middle.
+	HeadTail Strategy = "head+tail"
+	// Relevant keeps the chunks that share the most words with the question.
+	Relevant Strategy = "relevant"
+)
+
+// Strategies are the supported truncation strategies.
+var Strategies = []Strategy{Head, Tail, HeadTail, Relevant}
+
+const (
+	// ChunkTokens is the size of the chunks the relevant strategy chooses from.
+	ChunkTokens = 512
+	// segmentTokens is the most tokens in a segment, longer lines are split.
+	segmentTokens = 256
+	// markerTokens is left for each marker that shows where text was cut.
+	markerTokens = 16
+)
+
+var encoding = sync.OnceValues(func() (*tiktoken.Tiktoken, error) {
+	tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+	return tiktoken.GetEncoding("cl100k_base")
+})
+
+// Count estimates the tokens in the text, with the cl100k_base encoding.
+// If the encoding can't be loaded, it falls back to 4 characters a token.
+func Count(text string) int {
+	enc, err := encoding()
+	if err != nil {
+		return len(text) / 4
+	}
+	return len(enc.EncodeOrdinary(text))
+}
+
+// ParseStrategy reads a strategy by name. An empty name is HeadTail.
+func ParseStrategy(name string) (Strategy, error) {
+	if name == "" {
+		return HeadTail, nil
+	}
+	for _, s := range Strategies {
+		if string(s) == strings.ToLower(name) {
+			return s, nil
+		}
+	}
+	names := make([]string, 0, len(Strategies))
+	for _, s := range Strategies {
+		names = append(names, string(s))
+	}
+	return "", fmt.Errorf("Unknown truncation strategy %q, use one of: %s", name, strings.Join(names, ", "))
+}
+
+// Cut describes the text that was removed by Truncate.
+type Cut struct {
+	Strategy Strategy
+	// Tokens is the size of the text before it was cut
+	Tokens int
+	// Kept is the size of the text after it was cut
+	Kept int
+	// Removed are the line ranges that were cut, e.g. "lines 120-480"
+	Removed []string
+}
+
+// String describes the cut, e.g. "kept 8,000 of 50,000 tokens (head+tail, cut lines 200-4000)"
+func (c *Cut) String() string {
+	return fmt.Sprintf("kept %s of %s tokens (%s, cut %s)", Format(c.Kept), Format(c.Tokens), c.Strategy, strings.Join(c.Removed, ", "))
+}
+
+// segment is a line, or part of a long line.
+type segment struct {
+	text   string
+	line   int
+	tokens int
+}
+
+// Truncate cuts the text down to the limit, keeping the part chosen by the strategy.
+// Each cut is replaced with a marker, so the model knows text is missing.
+// The query is the question, used by the Relevant strategy. If it shares no words
+// with the text, HeadTail is used instead.
+// It returns a nil Cut if the text already fits. A limit smaller than a marker keeps only the marker.
+func Truncate(text string, limit int, strategy Strategy, query string) (string, *Cut) {
+	total := Count(text)
+	if total <= limit {
+		return text, nil
+	}
+	segments := split(text)
+	keep := make([]bool, len(segments))
+	switch strategy {
+	case Head:
+		fill(segments, keep, limit-markerTokens, false)
+	case Tail:
+		fill(segments, keep, limit-markerTokens, true)
+	case Relevant:
+		if !relevant(segments, keep, limit, query) {
+			strategy = HeadTail
+			headTail(segments, keep, limit)
+		}
+	default:
+		strategy = HeadTail
+		headTail(segments, keep, limit)
+	}
+
+	var b strings.Builder
+	cut := &Cut{Strategy: strategy, Tokens: total}
+	for i := 0; i < len(segments); {
+		if keep[i] {
+			b.WriteString(segments[i].text)
+			i++
+			continue
+		}
+		start, tokens := i, 0
+		for ; i < len(segments) && !keep[i]; i++ {
+			tokens += segments[i].tokens
+		}
+		first, last := segments[start].line, segments[i-1].line
+		lines := fmt.Sprintf("lines %d-%d", first, last)
+		if first == last {
+			lines = fmt.Sprintf("line %d", first)
+		}
+		cut.Removed = append(cut.Removed, lines)
+		fmt.Fprintf(&b, "\n[... %s tokens cut, %s ...]\n", Format(tokens), lines)
+	}
+	truncated := b.String()
+	cut.Kept = Count(truncated)
+	return truncated, cut
+}
+
+// split breaks the text into lines, splitting lines that are too long to cut around.
+// Joined back together, the segments are the original text.
+func split(text string) []segment {
+	enc, err := encoding()
+	segments := []segment{}
+	for i, line := range strings.SplitAfter(text, "\n") {
+		if line == "" {
+			continue
+		}
+		tokens := Count(line)
+		if tokens <= segmentTokens || err != nil {
+			segments = append(segments, segment{text: line, line: i + 1, tokens: tokens})
+			continue
+		}
+		encoded := enc.EncodeOrdinary(line)
+		for start := 0; start < len(encoded); start += segmentTokens {
+			end := min(start+segmentTokens, len(encoded))
+			segments = append(segments, segment{text: enc.Decode(encoded[start:end]), line: i + 1, tokens: end - start})
+		}
+	}
+	return segments
+}
+
+// fill keeps segments from the start, or the end, until the limit is reached.
+func fill(segments []segment, keep []bool, limit int, fromEnd bool) int {
+	used := 0
+	for n := range segments {
+		i := n
+		if fromEnd {
+			i = len(segments) - 1 - n
+		}
+		if keep[i] {
+			continue
+		}
+		if used+segments[i].tokens > limit {
+			break
+		}
+		keep[i] = true
+		used += segments[i].tokens
+	}
+	return used
+}
+
+// headTail keeps half the limit from the start, and the rest from the end.
+func headTail(segments []segment, keep []bool, limit int) {
+	limit -= markerTokens
+	used := fill(segments, keep, limit/2, false)
+	fill(segments, keep, limit-used, true)
+}
+
+// relevant keeps the chunks that share the most words with the query, in their original order.
+// It returns false if no chunk shares a word with the query.
+func relevant(segments []segment, keep []bool, limit int, query string) bool {
+	terms := map[string]bool{}
+	for _, w := range words(query) {
+		terms[w] = true
+	}
+	if len(terms) == 0 {
+		return false
+	}
+
+	type chunk struct {
+		start, end int
+		tokens     int
+		score      float64
+	}
+	chunks := []chunk{}
+	for start := 0; start < len(segments); {
+		c := chunk{start: start}
+		var text strings.Builder
+		for c.end = start; c.end < len(segments) && (c.end == start || c.tokens+segments[c.end].tokens <= ChunkTokens); c.end++ {
+			c.tokens += segments[c.end].tokens
+			text.WriteString(segments[c.end].text)
+		}
+		counts := map[string]int{}
+		for _, w := range words(text.String()) {
+			counts[w]++
+		}
+		// Each term counts once, with diminishing weight for repeats
+		for term := range terms {
+			if n := counts[term]; n > 0 {
+				c.score += 1 + math.Log(float64(n))
+			}
+		}
+		chunks = append(chunks, c)
+		start = c.end
+	}
+
+	ranked := make([]chunk, len(chunks))
+	copy(ranked, chunks)
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].score > ranked[j].score })
+	if ranked[0].score == 0 {
+		return false
+	}
+	used := 0
+	for _, c := range ranked {
+		// Every chunk may be followed by a cut
+		if c.score == 0 || used+c.tokens+markerTokens > limit {
+			continue
+		}
+		for i := c.start; i < c.end; i++ {
+			keep[i] = true
+		}
+		used += c.tokens + markerTokens
+	}
+	return used > 0
+}
+
+// stopWords are too common to say what a question is about.
+var stopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "are": true, "but": true, "not": true, "you": true, "all": true,
+	"can": true, "was": true, "one": true, "our": true, "out": true, "has": true, "how": true, "what": true,
+	"why": true, "this": true, "that": true, "with": true, "from": true, "they": true, "have": true, "there": true,
+	"which": true, "when": true, "where": true, "will": true, "does": true, "into": true, "about": true, "tell": true,
+}
+
+// words are the lowercase words of the text, with at least 3 letters, that aren't stop words.
+func words(text string) []string {
+	found := []string{}
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	}) {
+		if len(w) >= 3 && !stopWords[w] {
+			found = append(found, w)
+		}
+	}
+	return found
+}
+
+// Format formats a number of tokens with thousands separators, e.g. 1,204
+func Format(n int) string {
+	digits := fmt.Sprint(n)
+	var b strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteRune(',')
+		}
+		b.WriteRune(d)
+	}
+	return b.String()
+}
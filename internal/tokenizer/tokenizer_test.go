@@ -0,0 +1,172 @@
+package tokenizer
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// numbered returns n lines, each naming its line number.
+func numbered(n int, line func(i int) string) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		b.WriteString(line(i) + "\n")
+	}
+	return b.String()
+}
+
+func plain(i int) string {
+	return fmt.Sprintf("line %d of the log file", i)
+}
+
+var marker = regexp.MustCompile(`\n\[\.\.\. [0-9,]+ tokens cut, lines? [0-9-]+ \.\.\.\]\n`)
+
+func TestTruncateFits(t *testing.T) {
+	text := numbered(10, plain)
+	got, cut := Truncate(text, Count(text), Head, "")
+	if got != text || cut != nil {
+		t.Errorf("Truncate = %q, %v, want the text back without a cut", got, cut)
+	}
+}
+
+func TestTruncateStrategies(t *testing.T) {
+	text := numbered(1000, plain)
+	limit := 500
+	tests := []struct {
+		strategy Strategy
+		query    string
+		want     Strategy
+		kept     []string
+		cut      []string
+	}{
+		{Head, "", Head, []string{"line 1 of", "line 2 of"}, []string{"line 1000 of"}},
+		{Tail, "", Tail, []string{"line 1000 of", "line 999 of"}, []string{"line 1 of"}},
+		{HeadTail, "", HeadTail, []string{"line 1 of", "line 1000 of"}, []string{"line 500 of"}},
+		// Without words shared with the text, relevant keeps the start and end
+		{Relevant, "kubernetes", HeadTail, []string{"line 1 of", "line 1000 of"}, []string{"line 500 of"}},
+		{Relevant, "", HeadTail, []string{"line 1 of", "line 1000 of"}, []string{"line 500 of"}},
+		{"", "", HeadTail, []string{"line 1 of", "line 1000 of"}, []string{"line 500 of"}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.strategy)+" "+tt.query, func(t *testing.T) {
+			got, cut := Truncate(text, limit, tt.strategy, tt.query)
+			if cut == nil {
+				t.Fatal("Truncate didn't cut a text over the limit")
+			}
+			if cut.Strategy != tt.want {
+				t.Errorf("strategy = %s, want %s", cut.Strategy, tt.want)
+			}
+			if cut.Kept > limit || cut.Kept != Count(got) || cut.Tokens != Count(text) {
+				t.Errorf("cut = %+v, want at most %d of %d tokens kept", cut, limit, Count(text))
+			}
+			for _, line := range tt.kept {
+				if !strings.Contains(got, line+" ") {
+					t.Errorf("%q was cut, want it kept", line)
+				}
+			}
+			for _, line := range tt.cut {
+				if strings.Contains(got, line+" ") {
+					t.Errorf("%q was kept, want it cut", line)
+				}
+			}
+			markers := marker.FindAllString(got, -1)
+			if len(markers) == 0 || len(markers) != len(cut.Removed) {
+				t.Errorf("markers = %q, removed = %v, want one marker per cut", markers, cut.Removed)
+			}
+		})
+	}
+}
+
+func TestTruncateMarkers(t *testing.T) {
+	text := numbered(1000, plain)
+	got, cut := Truncate(text, 200, Head, "")
+	last := marker.FindString(got)
+	if !strings.HasSuffix(got, last) || len(cut.Removed) != 1 || !strings.HasSuffix(cut.Removed[0], "-1000") {
+		t.Errorf("Truncate = ...%q, removed %v, want one marker for the lines cut from the end", got[max(0, len(got)-80):], cut.Removed)
+	}
+	if !strings.Contains(last, cut.Removed[0]) {
+		t.Errorf("marker %q doesn't name the %s cut", last, cut.Removed[0])
+	}
+	if s := cut.String(); !strings.HasPrefix(s, "kept ") || !strings.Contains(s, "(head, cut lines ") {
+		t.Errorf("cut.String() = %q", s)
+	}
+
+	got, cut = Truncate(text, 200, Tail, "")
+	if !strings.HasPrefix(got, marker.FindString(got)) || !strings.HasPrefix(cut.Removed[0], "lines 1-") {
+		t.Errorf("Truncate = %q..., removed %v, want one marker for the lines cut from the start", got[:80], cut.Removed)
+	}
+}
+
+func TestTruncateRelevant(t *testing.T) {
+	text := numbered(3000, func(i int) string {
+		if i >= 1500 && i < 1510 {
+			return fmt.Sprintf("line %d configures the kubernetes ingress", i)
+		}
+		return plain(i)
+	})
+	got, cut := Truncate(text, 1000, Relevant, "how is the kubernetes ingress configured?")
+	if cut == nil || cut.Strategy != Relevant {
+		t.Fatalf("cut = %v, want the relevant strategy", cut)
+	}
+	for i := 1500; i < 1510; i++ {
+		if !strings.Contains(got, fmt.Sprintf("line %d configures", i)) {
+			t.Errorf("line %d was cut, want the lines about the question kept", i)
+		}
+	}
+	if cut.Kept > 1000 {
+		t.Errorf("kept %d tokens, want at most 1000", cut.Kept)
+	}
+	// The kept chunks stay in their original order
+	if first, second := strings.Index(got, "line 1500 "), strings.Index(got, "line 1509 "); first > second {
+		t.Errorf("line 1500 is at %d and line 1509 at %d, want the original order", first, second)
+	}
+}
+
+func TestTruncateLongLine(t *testing.T) {
+	// A single line longer than the limit is cut inside the line, in segments of segmentTokens
+	text := strings.Repeat("token ", 5000)
+	got, cut := Truncate(text, 2000, HeadTail, "")
+	if cut == nil || cut.Kept > 2000 || !strings.HasPrefix(got, "token token") || !strings.HasSuffix(got, "token ") {
+		t.Errorf("cut = %v, want the start and end of the line kept under the limit", cut)
+	}
+	if len(cut.Removed) != 1 || cut.Removed[0] != "line 1" {
+		t.Errorf("removed = %v, want part of line 1", cut.Removed)
+	}
+}
+
+func TestTruncateTinyLimit(t *testing.T) {
+	// A limit smaller than a marker keeps none of the text, only the marker
+	text := numbered(100, plain)
+	for _, strategy := range Strategies {
+		for _, limit := range []int{0, 1, markerTokens - 1} {
+			got, cut := Truncate(text, limit, strategy, "log")
+			if cut == nil || strings.Contains(got, "of the log file") {
+				t.Errorf("Truncate(%s, %d) = %q, want all the text cut", strategy, limit, got)
+				continue
+			}
+			if !marker.MatchString(got) || len(cut.Removed) != 1 || cut.Removed[0] != "lines 1-100" {
+				t.Errorf("Truncate(%s, %d) = %q, removed %v, want a marker for every line", strategy, limit, got, cut.Removed)
+			}
+		}
+	}
+}
+
+func TestParseStrategy(t *testing.T) {
+	for name, want := range map[string]Strategy{"": HeadTail, "head": Head, "TAIL": Tail, "head+tail": HeadTail, "relevant": Relevant} {
+		if got, err := ParseStrategy(name); err != nil || got != want {
+			t.Errorf("ParseStrategy(%q) = %s, %v, want %s", name, got, err, want)
+		}
+	}
+	if _, err := ParseStrategy("middle"); err == nil || !strings.Contains(err.Error(), "head+tail") {
+		t.Errorf("ParseStrategy(middle) = %v, want the strategies listed", err)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	for n, want := range map[int]string{0: "0", 999: "999", 1000: "1,000", 1234567: "1,234,567"} {
+		if got := Format(n); got != want {
+			t.Errorf("Format(%d) = %s, want %s", n, got, want)
+		}
+	}
+}
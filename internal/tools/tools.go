@@ -2,12 +2,14 @@ package tools
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
-	"regexp"
 	"strings"
 
 	aiutil "github.com/ztkent/ai-util"
+	"github.com/ztkent/moki/internal/resources"
+	"github.com/ztkent/moki/internal/tokenizer"
 )
 
 func ReadFromStdinPipe() string {
@@ -24,41 +26,125 @@ func ReadFromStdinPipe() string {
 	return ""
 }
 
+// IsTerminal reports whether the file is an interactive terminal.
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return (info.Mode() & os.ModeCharDevice) != 0
+}
+
+// StripCodeFences removes markdown code fences from a response.
+// If the response contains fenced code blocks, only their contents are returned.
+func StripCodeFences(response string) string {
+	lines := strings.Split(strings.TrimSpace(response), "\n")
+	var code []string
+	inBlock, foundBlock := false, false
+	for _, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inBlock = !inBlock
+			foundBlock = true
+			continue
+		}
+		if inBlock {
+			code = append(code, line)
+		}
+	}
+	if foundBlock {
+		return strings.TrimSpace(strings.Join(code, "\n"))
+	}
+
+	// Single line answers are sometimes wrapped in inline code
+	trimmed := strings.TrimSpace(response)
+	if !strings.Contains(trimmed, "\n") && len(trimmed) > 1 && strings.HasPrefix(trimmed, "`") && strings.HasSuffix(trimmed, "`") {
+		return strings.Trim(trimmed, "`")
+	}
+	return trimmed
+}
+
+// ResourceOptions control how resources are attached to a conversation.
+type ResourceOptions struct {
+	// Confirm asks before a -cmd: resource runs, without it commands are refused
+	Confirm resources.ConfirmFunc
+	// Truncate chooses what is kept of a resource that doesn't fit
+	Truncate tokenizer.Strategy
+	// MaxTokens caps a single resource, if it is 0 a resource can fill the space left in the conversation
+	MaxTokens int
+}
+
 // Determine if the user's input contains a resource command
-// There is usually some limit to the number of tokens
-func ManageResources(conv *aiutil.Conversation, userInput string) (string, []string, error) {
+// Each resource is estimated before it is attached, and cut down if it doesn't fit in the conversation.
+// It returns the message without the resources, the resources found, and warnings for any that were cut.
+func ManageResources(ctx context.Context, conv *aiutil.Conversation, userInput string, opts ResourceOptions) (string, []string, []string, error) {
 	resourcesFound := []string{}
 	if conv == nil {
-		return userInput, resourcesFound, fmt.Errorf("Failed to ManageResources: Conversation is nil")
+		return userInput, resourcesFound, nil, fmt.Errorf("Failed to ManageResources: Conversation is nil")
 	} else if len(userInput) == 0 {
-		return userInput, resourcesFound, nil
+		return userInput, resourcesFound, nil, nil
 	}
 
+	// Check the directives before reading stdin, so a typo doesn't consume it
+	message, directives, err := resources.ParseDirectives(userInput)
+	if err != nil {
+		return userInput, resourcesFound, nil, err
+	}
+	fit := &resources.Fit{Strategy: opts.Truncate, MaxTokens: opts.MaxTokens, Query: message}
+
 	// Check if there is any input from stdin
 	stdinInput := ReadFromStdinPipe()
 	if stdinInput != "" {
-		resourcesFound = append(resourcesFound, "stdin:"+stdinInput)
-		conv.AddReference("User Input", stdinInput)
+		tokens, err := fit.Attach(conv, "User Input", stdinInput)
+		if err != nil {
+			return userInput, resourcesFound, fit.Warnings, err
+		}
+		// The input is already in the conversation, so only a summary is listed
+		lines, unit := strings.Count(strings.TrimRight(stdinInput, "\n"), "\n")+1, "lines"
+		if lines == 1 {
+			unit = "line"
+		}
+		resourcesFound = append(resourcesFound, fmt.Sprintf("stdin (%d %s, ~%d tokens)", lines, unit, tokens))
 	}
 
-	// Only supporting URL and File resources for now
-	var resourceCommands = []string{"url", "file"}
-	for _, cmd := range resourceCommands {
-		re := regexp.MustCompile(fmt.Sprintf(`\-%s:(.*)`, cmd))
-		matches := re.FindAllStringSubmatch(strings.ToLower(userInput), -1)
-		for _, match := range matches {
-			if len(match) > 1 {
-				resource := strings.TrimSpace(match[1])
-				resourcesFound = append(resourcesFound, cmd+":"+resource)
-				err := aiutil.AddResource(conv, resource, cmd)
-				if err != nil {
-					return userInput, resourcesFound, err
-				}
-				userInput = strings.Replace(userInput, "-"+cmd+":"+resource, "", -1)
-			}
+	for _, directive := range directives {
+		summary, err := addResource(ctx, conv, directive, opts.Confirm, fit)
+		if err != nil {
+			return userInput, resourcesFound, fit.Warnings, err
 		}
+		resourcesFound = append(resourcesFound, directive.Type+":"+directive.Value+" ("+summary+")")
 	}
-	return userInput, resourcesFound, nil
+	return message, resourcesFound, fit.Warnings, nil
+}
+
+// addResource adds a single resource to the conversation, and returns a summary of it.
+func addResource(ctx context.Context, conv *aiutil.Conversation, directive resources.Directive, confirm resources.ConfirmFunc, fit *resources.Fit) (string, error) {
+	resource := directive.Value
+	switch directive.Type {
+	case "url":
+		return resources.AddURL(ctx, conv, resource, fit)
+	case "file":
+		return resources.AddFile(conv, resource, fit)
+	case "git":
+		return resources.AddGit(ctx, conv, resource, fit)
+	case "cmd":
+		return resources.AddCommand(ctx, conv, resource, confirm, fit)
+	}
+
+	// Directories and globs attach many files, so a summary of them is returned
+	var files *resources.Files
+	var err error
+	if directive.Type == "dir" {
+		files, err = resources.Dir(resource)
+	} else {
+		files, err = resources.Glob(resource)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := resources.AddFiles(conv, files, fit); err != nil {
+		return "", err
+	}
+	return files.Summary(), nil
 }
 
 var HelpMessage = `Usage:
@@ -68,24 +154,87 @@ var HelpMessage = `Usage:
 	# Provide additional context
 	cat moki.go | moki [tell me about this code]
 	moki [tell me about this code]    -file:moki.go
+	moki [compare these notes] -file:"Meeting Notes.md" -file:TODO.md
 	moki [tell me about this project] -url:https://github.com/ztkent/moki
+	moki [how is this package tested] -dir:internal/session
+	moki [where are errors wrapped] -glob:internal/**/*.go
+	moki [review this diff] -git:diff
+	moki [what changed recently] -git:log:5
+	moki [why are these tests failing] -cmd:"go test ./..."
+
+	# Script with the answer
+	moki -o raw [list all go files] | sh
+	moki -o json [list all go files] | jq .answer
+
+	# Confirm, edit and run the suggested command
+	moki -x [find all go files changed this week]
 
 	# Start a conversation with the assistant
 	moki -c
 	moki -c -m=turbo -max-tokens=100000 -t=0.5
 
+	# Use a local OpenAI-compatible server (Ollama, llama.cpp, vLLM)
+	moki -llm=openai-compatible -base-url=http://localhost:11434/v1 -m=llama3.2 [your message]
+	moki -llm=openai-compatible -base-url=http://localhost:11434/v1 models
+
+	# Explain an existing command
+	moki explain -- tar -xzvf archive.tar.gz -C /tmp
+	moki explain -o json -- 'find . -name "*.log" | xargs rm'
+
+	# Draft a commit message for the staged changes
+	git add -p && moki commit
+
+	# Turn the current command line into a command with Ctrl-G
+	eval "$(moki shell-init bash)"
+
+	# Resume a saved conversation
+	moki sessions list
+	moki -c -resume <id>
+
+Commands:
+	shell-init bash|zsh|fish:  Print the Ctrl-G shell integration snippet
+	models:                    List the models available from the provider
+	explain [-o json] -- cmd:  Break a command down into its flags, pipes and redirections
+	commit [-print]:           Draft a commit message for the staged changes
+	sessions list:             List saved conversations
+	sessions show <id>:        Print a saved conversation
+	sessions delete <id>:      Delete a saved conversation
+	usage [-days N]:           Summarize the tokens and cost per day and model
+
 Flags:
 	-h:                        Show this message
+	-o:                        Output format for one-shot requests: text, json or raw
 	-c:                        Start a conversation with Moki
+	-no-color:                 Print raw markdown in conversations, instead of rendering it
+	-x:                        Confirm, edit and run the suggested command in $SHELL
+	-resume:                   Resume a saved conversation by session id
+	-profile:                  Use a named profile from the config file
+	-ignore-limits:            Send requests even if they go over a spending limit
+	-env:                      Send the environment as context (default true)
+	-env-fields:               Environment fields to send: os,distro,shell,package_managers,cwd,git
+	-truncate:                 What to keep of a resource that doesn't fit: head, tail, head+tail or relevant
+	-resource-tokens:          The most tokens a single resource can use (default the space left)
+	-compact-at:               Summarize older messages when a conversation fills this fraction of its tokens (default 0.8)
+	-retries:                  Retry a request that fails with a rate limit or server error (default 3)
+	-fallback:                 Provider/model pairs to try in order when a request fails, e.g. anthropic/sonnet
 	-llm:                      Set the LLM Provider
 	-m:                        Set the model to use for the LLM response
+	-base-url:                 Set the base URL of the LLM API
 	-max-tokens: 	           Set the maximum number of tokens to generate per response
 	-t:                        Set the temperature for the LLM response
 	-d:                        Show debug logging
 
+Config:
+	- ~/.config/moki/config.yaml, and .moki.yaml in the project
+	- Flags override env vars (MOKI_*), then profiles, then config files
+
 API Keys:
 	- export OPENAI_API_KEY=<your key>
 	- export REPLICATE_API_TOKEN=<your key>
+	- export ANTHROPIC_API_KEY=<your key>
+	- export GEMINI_API_KEY=<your key>
+	- export OPENAI_COMPATIBLE_API_KEY=<your key>  (optional, for -llm=openai-compatible)
+	- export MOKI_MOCK_FIXTURE=<fixture.json>  (for -llm=mock, no key required)
 
 Model Options:
 	- OpenAI:
@@ -101,4 +250,17 @@ Model Options:
 		- meta-llama-3-8b-instruct, aka: l3-8b-instruct
 		- meta-llama-3-70b, aka: l3-70b
 		- meta-llama-3-70b-instruct, aka: l3-70b-instruct
+	- Anthropic:
+		- claude-3-5-haiku-latest, aka: haiku
+		- claude-3-7-sonnet-latest, aka: sonnet37
+		- [Default] claude-sonnet-4-0, aka: sonnet
+		- claude-opus-4-0, aka: opus
+	- Gemini:
+		- gemini-2.0-flash, aka: flash2
+		- gemini-2.0-flash-lite, aka: flash-lite
+		- [Default] gemini-2.5-flash, aka: flash
+		- gemini-2.5-pro, aka: pro
+	- OpenAI-Compatible:
+		- Any model served at <base-url>/models, see: moki models
+		- [Default] the first model listed by the server
 `
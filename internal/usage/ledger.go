@@ -0,0 +1,155 @@
+package usage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Entry is a single request in the ledger.
+type Entry struct {
+	Time             time.Time `json:"time"`
+	Provider         string    `json:"provider"`
+	Model            string    `json:"model"`
+	Session          string    `json:"session,omitempty"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	Cost             float64   `json:"cost_usd"`
+	Priced           bool      `json:"priced"`
+}
+
+// Ledger keeps every request as a line of JSON in a single file.
+type Ledger struct {
+	Path string
+}
+
+// DefaultPath returns the ledger path under the XDG data home.
+// $XDG_DATA_HOME/moki/usage.jsonl, or ~/.local/share/moki/usage.jsonl if it is unset.
+func DefaultPath() (string, error) {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("Failed to find the home directory: %w", err)
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dataHome, "moki", "usage.jsonl"), nil
+}
+
+// NewLedger opens the ledger at path, creating its directory if needed.
+// If path is empty, the DefaultPath is used.
+func NewLedger(path string) (*Ledger, error) {
+	if path == "" {
+		defaultPath, err := DefaultPath()
+		if err != nil {
+			return nil, err
+		}
+		path = defaultPath
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("Failed to create usage directory %s: %w", filepath.Dir(path), err)
+	}
+	return &Ledger{Path: path}, nil
+}
+
+// Append adds an entry to the end of the ledger.
+// Each entry is a single write, so concurrent moki processes don't interleave lines.
+func (l *Ledger) Append(entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("Failed to encode usage: %w", err)
+	}
+	f, err := os.OpenFile(l.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("Failed to record usage: %w", err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("Failed to record usage: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Failed to record usage: %w", err)
+	}
+	return nil
+}
+
+// Entries reads the entries recorded at or after since. A missing ledger has no entries.
+// Lines that can't be read, e.g. cut off by a crash, are skipped.
+func (l *Ledger) Entries(since time.Time) ([]Entry, error) {
+	f, err := os.Open(l.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Entry{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Failed to read usage: %w", err)
+	}
+	defer f.Close()
+
+	entries := []Entry{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entry := Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if !entry.Time.Before(since) {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Failed to read usage: %w", err)
+	}
+	return entries, nil
+}
+
+// Summary is the usage of a model on a single day.
+type Summary struct {
+	Day      string
+	Provider string
+	Model    string
+	Requests int
+	Usage
+}
+
+// Summarize groups the entries by local day and model, most recent day first.
+func Summarize(entries []Entry) []Summary {
+	type key struct{ day, provider, model string }
+	groups := map[key]*Summary{}
+	for _, entry := range entries {
+		k := key{entry.Time.Local().Format(time.DateOnly), entry.Provider, entry.Model}
+		s, ok := groups[k]
+		if !ok {
+			s = &Summary{Day: k.day, Provider: k.provider, Model: k.model}
+			groups[k] = s
+		}
+		s.Requests++
+		s.Add(entry.Usage())
+	}
+
+	summaries := make([]Summary, 0, len(groups))
+	for _, s := range groups {
+		summaries = append(summaries, *s)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Day != summaries[j].Day {
+			return summaries[i].Day > summaries[j].Day
+		}
+		return summaries[i].Cost > summaries[j].Cost
+	})
+	return summaries
+}
+
+// Usage is the tokens and cost of the entry.
+func (e Entry) Usage() Usage {
+	return Usage{
+		PromptTokens:     e.PromptTokens,
+		CompletionTokens: e.CompletionTokens,
+		Cost:             e.Cost,
+		Priced:           e.Priced,
+	}
+}
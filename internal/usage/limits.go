@@ -0,0 +1,171 @@
+package usage
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultReserveTokens are set aside for the response when a request is checked, if ReserveTokens isn't set.
+const DefaultReserveTokens = 1024
+
+// Limits cap the spending per day, month and session, in dollars and tokens.
+// Unset limits aren't checked. Dollar limits only apply to models with a known price.
+type Limits struct {
+	DailyUSD      *float64 `yaml:"daily_usd,omitempty"`
+	MonthlyUSD    *float64 `yaml:"monthly_usd,omitempty"`
+	SessionUSD    *float64 `yaml:"session_usd,omitempty"`
+	DailyTokens   *int     `yaml:"daily_tokens,omitempty"`
+	MonthlyTokens *int     `yaml:"monthly_tokens,omitempty"`
+	SessionTokens *int     `yaml:"session_tokens,omitempty"`
+	// ReserveTokens are set aside for the response when a request is checked, up to its max tokens
+	ReserveTokens *int `yaml:"reserve_tokens,omitempty"`
+}
+
+// Merge overrides l with every limit that is set in o.
+func (l *Limits) Merge(o Limits) {
+	if o.DailyUSD != nil {
+		l.DailyUSD = o.DailyUSD
+	}
+	if o.MonthlyUSD != nil {
+		l.MonthlyUSD = o.MonthlyUSD
+	}
+	if o.SessionUSD != nil {
+		l.SessionUSD = o.SessionUSD
+	}
+	if o.DailyTokens != nil {
+		l.DailyTokens = o.DailyTokens
+	}
+	if o.MonthlyTokens != nil {
+		l.MonthlyTokens = o.MonthlyTokens
+	}
+	if o.SessionTokens != nil {
+		l.SessionTokens = o.SessionTokens
+	}
+	if o.ReserveTokens != nil {
+		l.ReserveTokens = o.ReserveTokens
+	}
+}
+
+// reserve returns the tokens set aside for a response that can use up to maxTokens, or any number if maxTokens is 0.
+// Responses are usually much shorter than their max tokens, so only part of it is reserved.
+// What the response really used is recorded after it, and counted against the next request.
+func (l Limits) reserve(maxTokens int) int {
+	reserve := DefaultReserveTokens
+	if l.ReserveTokens != nil {
+		reserve = *l.ReserveTokens
+	}
+	if maxTokens > 0 {
+		return min(reserve, maxTokens)
+	}
+	return reserve
+}
+
+// IsSet reports whether any limit is set.
+func (l Limits) IsSet() bool {
+	return l.DailyUSD != nil || l.MonthlyUSD != nil || l.SessionUSD != nil ||
+		l.DailyTokens != nil || l.MonthlyTokens != nil || l.SessionTokens != nil
+}
+
+// Spent is the usage so far today, this month and this session.
+type Spent struct {
+	Day     Usage
+	Month   Usage
+	Session Usage
+}
+
+// LimitError is returned for a request that would go over a limit.
+type LimitError struct {
+	// Period is "daily", "monthly" or "session"
+	Period  string
+	Spent   Usage
+	Request Usage
+	// Limit is formatted, e.g. "$5.00" or "100,000 tokens"
+	Limit string
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("Request refused, it would go over the %s limit of %s (%s spent, this request is ~%s). Use -ignore-limits to send it anyway",
+		e.Period, e.Limit, e.Spent, e.Request)
+}
+
+// Check returns a LimitError if adding the request to what was spent would go over a limit.
+func (l Limits) Check(spent Spent, request Usage) error {
+	periods := []struct {
+		name    string
+		spent   Usage
+		dollars *float64
+		tokens  *int
+	}{
+		{"session", spent.Session, l.SessionUSD, l.SessionTokens},
+		{"daily", spent.Day, l.DailyUSD, l.DailyTokens},
+		{"monthly", spent.Month, l.MonthlyUSD, l.MonthlyTokens},
+	}
+	for _, p := range periods {
+		if p.tokens != nil && p.spent.Tokens()+request.Tokens() > *p.tokens {
+			return &LimitError{Period: p.name, Spent: p.spent, Request: request, Limit: FormatTokens(*p.tokens) + " tokens"}
+		}
+		if p.dollars != nil && request.Priced && p.spent.Cost+request.Cost > *p.dollars {
+			return &LimitError{Period: p.name, Spent: p.spent, Request: request, Limit: fmt.Sprintf("$%.2f", *p.dollars)}
+		}
+	}
+	return nil
+}
+
+// Spent adds up the usage today, this month and this session.
+// Without a ledger, only the requests made by this tracker are known.
+func (t *Tracker) Spent(now time.Time) (Spent, error) {
+	t.mu.Lock()
+	ledger, session, total := t.ledger, t.session, t.total
+	t.mu.Unlock()
+	if ledger == nil {
+		return Spent{Day: total, Month: total, Session: total}, nil
+	}
+
+	// Sessions can be resumed, so every entry is read to find the earlier requests of this one
+	entries, err := ledger.Entries(time.Time{})
+	if err != nil {
+		return Spent{}, err
+	}
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+	spent := Spent{}
+	for _, entry := range entries {
+		if !entry.Time.Before(day) {
+			spent.Day.Add(entry.Usage())
+		}
+		if !entry.Time.Before(month) {
+			spent.Month.Add(entry.Usage())
+		}
+		if session != "" && entry.Session == session {
+			spent.Session.Add(entry.Usage())
+		}
+	}
+	// Requests outside a session, like one-shot requests, are a session of their own
+	if session == "" {
+		spent.Session = total
+	}
+	return spent, nil
+}
+
+// SetLimits sets the limits checked before each request.
+func (t *Tracker) SetLimits(limits Limits) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.limits = limits
+}
+
+// Allow checks the limits before a request is sent.
+// The prompt is checked with the tokens reserved for a response that can use up to maxCompletionTokens.
+func (t *Tracker) Allow(provider string, model string, promptTokens int, maxCompletionTokens int) error {
+	t.mu.Lock()
+	limits := t.limits
+	t.mu.Unlock()
+	if !limits.IsSet() {
+		return nil
+	}
+	spent, err := t.Spent(time.Now())
+	if err != nil {
+		return fmt.Errorf("Failed to check the spending limits: %w", err)
+	}
+	return limits.Check(spent, estimate(provider, model, promptTokens, limits.reserve(maxCompletionTokens)))
+}
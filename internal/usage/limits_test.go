@@ -0,0 +1,72 @@
+package usage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	aiutil "github.com/ztkent/ai-util"
+	"github.com/ztkent/moki/internal/providers"
+)
+
+func TestAllowReserve(t *testing.T) {
+	reserveTokens := func(n int) *int { return &n }
+	tests := []struct {
+		name          string
+		reserveTokens *int
+		maxTokens     int
+		want          int
+	}{
+		{"default", nil, 32768, DefaultReserveTokens},
+		{"configured", reserveTokens(4000), 65536, 4000},
+		{"capped by the max tokens", reserveTokens(4000), 500, 500},
+		{"unknown max tokens", nil, 0, DefaultReserveTokens},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Every request is over a zero limit, so the error shows what was reserved
+			sessionTokens := 0
+			tracker := NewTracker(nil)
+			tracker.SetLimits(Limits{SessionTokens: &sessionTokens, ReserveTokens: tt.reserveTokens})
+			var limitErr *LimitError
+			if err := tracker.Allow("openai", "gpt-4o", 100, tt.maxTokens); !errors.As(err, &limitErr) {
+				t.Fatalf("Allow = %v, want a LimitError", err)
+			}
+			if limitErr.Request.PromptTokens != 100 || limitErr.Request.CompletionTokens != tt.want {
+				t.Errorf("request = %+v, want 100 prompt tokens and %d reserved", limitErr.Request, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrackedClientAllow(t *testing.T) {
+	// The -max-tokens default is far over the limit, but only the reserve is checked
+	maxTokens := aiutil.DefaultMaxTokens
+	sessionTokens := 4000
+	mock, err := providers.NewMockClient(aiutil.ClientConfig{Provider: string(providers.Mock), Model: providers.DefaultMockModel, MaxTokens: &maxTokens}, providers.MockFixture{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := NewTracker(nil)
+	tracker.SetLimits(Limits{SessionTokens: &sessionTokens})
+	client := tracker.Wrap(mock)
+
+	conv := aiutil.NewConversation("system", 10000, false)
+	if _, err := client.SendCompletionRequest(context.Background(), conv, "hello"); err != nil {
+		t.Fatalf("error = %v, want a short question sent", err)
+	}
+
+	// What was really used counts against the next request
+	long := strings.Repeat("word ", 1000)
+	if _, err := client.SendCompletionRequest(context.Background(), conv, long); err != nil {
+		t.Fatalf("error = %v, want the request under the limit sent", err)
+	}
+	var limitErr *LimitError
+	if _, err := client.SendCompletionRequest(context.Background(), conv, "hello"); !errors.As(err, &limitErr) {
+		t.Fatalf("error = %v, want the spent tokens over the limit", err)
+	}
+	if limitErr.Spent.Tokens() != tracker.Total().Tokens() {
+		t.Errorf("spent = %s, want the recorded %s", limitErr.Spent, tracker.Total())
+	}
+}
@@ -0,0 +1,226 @@
+package usage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	aiutil "github.com/ztkent/ai-util"
+	"github.com/ztkent/moki/internal/providers"
+	"github.com/ztkent/moki/internal/tokenizer"
+)
+
+// Usage is the tokens used by one or more requests, and what they cost.
+// Providers don't report usage for streamed responses, so the tokens are estimated.
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+	Cost             float64
+	// Priced is set if the price of any of the models was known
+	Priced bool
+}
+
+// Tokens is the total number of tokens used.
+func (u Usage) Tokens() int {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+// Add adds the tokens and cost of another usage.
+func (u *Usage) Add(o Usage) {
+	u.PromptTokens += o.PromptTokens
+	u.CompletionTokens += o.CompletionTokens
+	u.Cost += o.Cost
+	u.Priced = u.Priced || o.Priced
+}
+
+// String formats the usage, e.g. "1,204 tokens · $0.003".
+// The cost is left out if no price was known.
+func (u Usage) String() string {
+	if !u.Priced {
+		return FormatTokens(u.Tokens()) + " tokens"
+	}
+	return FormatTokens(u.Tokens()) + " tokens · " + FormatCost(u.Cost)
+}
+
+// FormatTokens formats a number of tokens with thousands separators, e.g. 1,204
+func FormatTokens(tokens int) string {
+	return tokenizer.Format(tokens)
+}
+
+// FormatCost formats a cost in dollars, with more precision for small amounts.
+func FormatCost(cost float64) string {
+	switch {
+	case cost == 0:
+		return "$0.00"
+	case cost < 0.001:
+		return "<$0.001"
+	case cost < 1:
+		return fmt.Sprintf("$%.3f", cost)
+	default:
+		return fmt.Sprintf("$%.2f", cost)
+	}
+}
+
+// Tracker adds up the usage of every request, and records each one in the ledger.
+// It is shared by the clients it wraps, so the total survives switching models.
+type Tracker struct {
+	ledger  *Ledger
+	mu      sync.Mutex
+	limits  Limits
+	session string
+	last    Usage
+	total   Usage
+	err     error
+}
+
+// NewTracker creates a tracker that records requests in the ledger.
+// If ledger is nil, usage is only kept in memory.
+func NewTracker(ledger *Ledger) *Tracker {
+	return &Tracker{ledger: ledger}
+}
+
+// Record adds a request to the totals and the ledger, and returns its usage.
+// A failure to write the ledger is kept for Err, so it never fails the request.
+func (t *Tracker) Record(provider string, model string, promptTokens int, completionTokens int) Usage {
+	used := estimate(provider, model, promptTokens, completionTokens)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.last = used
+	t.total.Add(used)
+	if t.ledger != nil {
+		err := t.ledger.Append(Entry{
+			Time:             time.Now(),
+			Provider:         provider,
+			Model:            model,
+			Session:          t.session,
+			PromptTokens:     promptTokens,
+			CompletionTokens: completionTokens,
+			Cost:             used.Cost,
+			Priced:           used.Priced,
+		})
+		if err != nil {
+			t.err = err
+		}
+	}
+	return used
+}
+
+// SetSession records the session id with each request that follows.
+func (t *Tracker) SetSession(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.session = id
+}
+
+// Last is the usage of the most recent request.
+func (t *Tracker) Last() Usage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.last
+}
+
+// Total is the usage of every request since the tracker was created.
+func (t *Tracker) Total() Usage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.total
+}
+
+// Err is the last error writing to the ledger, if any.
+func (t *Tracker) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// estimate prices the tokens of a request, if the price of the model is known.
+func estimate(provider string, model string, promptTokens int, completionTokens int) Usage {
+	used := Usage{PromptTokens: promptTokens, CompletionTokens: completionTokens}
+	if price, ok := providers.PriceOf(provider, model); ok {
+		used.Cost = price.Cost(promptTokens, completionTokens)
+		used.Priced = true
+	}
+	return used
+}
+
+// Wrap returns a client that records the usage of each request sent through it.
+func (t *Tracker) Wrap(client aiutil.Client) aiutil.Client {
+	return &trackedClient{Client: client, tracker: t}
+}
+
+// trackedClient checks the limits before each request, and records its usage after.
+type trackedClient struct {
+	aiutil.Client
+	tracker *Tracker
+}
+
+// SendCompletionRequest sends the request, and records its usage once it succeeds.
+func (c *trackedClient) SendCompletionRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string) (string, error) {
+	promptTokens := promptTokens(conv, userPrompt)
+	if err := c.allow(promptTokens); err != nil {
+		return "", err
+	}
+	response, err := c.Client.SendCompletionRequest(ctx, conv, userPrompt)
+	if err != nil {
+		return response, err
+	}
+	c.record(promptTokens, response)
+	return response, nil
+}
+
+// SendStreamRequest streams the response, and records its usage before the response channel is closed.
+// A request over a limit is refused with a LimitError, like any other error.
+// Cancelled requests are recorded with the part of the answer that was streamed.
+func (c *trackedClient) SendStreamRequest(ctx context.Context, conv *aiutil.Conversation, userPrompt string, responseChan chan string, errChan chan error) {
+	promptTokens := promptTokens(conv, userPrompt)
+	if err := c.allow(promptTokens); err != nil {
+		errChan <- err
+		close(errChan)
+		close(responseChan)
+		return
+	}
+	chunks := make(chan string)
+	done := make(chan string)
+	go func() {
+		var answer strings.Builder
+		for chunk := range chunks {
+			answer.WriteString(chunk)
+			// The reader may have stopped after an error, so don't wait on it once the request is over
+			select {
+			case responseChan <- chunk:
+			case <-ctx.Done():
+			}
+		}
+		done <- answer.String()
+	}()
+
+	c.Client.SendStreamRequest(ctx, conv, userPrompt, chunks, errChan)
+	if answer := <-done; answer != "" {
+		c.record(promptTokens, answer)
+	}
+	close(responseChan)
+}
+
+func (c *trackedClient) allow(promptTokens int) error {
+	config := c.GetConfig()
+	return c.tracker.Allow(config.Provider, config.Model, promptTokens, providers.MaxCompletionTokens(config))
+}
+
+func (c *trackedClient) record(promptTokens int, answer string) {
+	config := c.GetConfig()
+	c.tracker.Record(config.Provider, config.Model, promptTokens, tokenizer.Count(answer))
+}
+
+// promptTokens estimates the tokens sent with a request, the conversation so far and the new prompt.
+func promptTokens(conv *aiutil.Conversation, userPrompt string) int {
+	if conv == nil {
+		return 0
+	}
+	conv.Lock()
+	tokens := conv.TokenCount
+	conv.Unlock()
+	return tokens + tokenizer.Count(userPrompt)
+}